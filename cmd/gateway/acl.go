@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/acl"
+	"github.com/dashjay/overlay_oss/pkg/auth"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+// maxACLSize bounds the AccessControlPolicy body of Put*Acl.
+const maxACLSize = 64 << 10
+
+// grantHeaders maps the x-amz-grant-* headers to their permission.
+var grantHeaders = map[s3types.Permission]string{
+	s3types.PermissionFullControl: "x-amz-grant-full-control",
+	s3types.PermissionRead:        "x-amz-grant-read",
+	s3types.PermissionReadAcp:     "x-amz-grant-read-acp",
+	s3types.PermissionWrite:       "x-amz-grant-write",
+	s3types.PermissionWriteAcp:    "x-amz-grant-write-acp",
+}
+
+// requestGrants expands the x-amz-acl or x-amz-grant-* headers of a write,
+// nil when it has neither. A canned ACL names the requester as owner.
+func requestGrants(r *http.Request) ([]s3types.Grant, error) {
+	canned := r.Header.Get("x-amz-acl")
+	var grants []s3types.Grant
+	for _, permission := range acl.Permissions {
+		v := r.Header.Get(grantHeaders[permission])
+		if v == "" {
+			continue
+		}
+		if canned != "" {
+			return nil, s3error.S3Error{
+				OriginError: fmt.Errorf("specifying both Canned ACLs and Header Grants is not allowed"),
+				Code:        s3error.ErrorCodeInvalidRequest,
+			}
+		}
+		parsed, err := acl.ParseGrants(permission, v)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, parsed...)
+	}
+	if canned != "" {
+		owner := requestOwner(r)
+		return acl.Canned(canned, owner.ID, owner.DisplayName)
+	}
+	return grants, nil
+}
+
+// decodeAccessControlPolicy reads the grants of a Put*Acl body.
+func decodeAccessControlPolicy(r *http.Request) ([]s3types.Grant, error) {
+	var body types.AccessControlPolicy
+	if err := decodeXML(r, maxACLSize, &body, s3error.ErrorCodeMalformedACLError); err != nil {
+		return nil, err
+	}
+	grants := make([]s3types.Grant, len(body.Grants))
+	for i, grant := range body.Grants {
+		grants[i].Permission = s3types.Permission(grant.Permission)
+		if g := grant.Grantee; g != nil {
+			grants[i].Grantee = &s3types.Grantee{Type: s3types.Type(g.Type)}
+			if g.ID != "" {
+				grants[i].Grantee.ID = aws.String(g.ID)
+			}
+			if g.DisplayName != "" {
+				grants[i].Grantee.DisplayName = aws.String(g.DisplayName)
+			}
+			if g.URI != "" {
+				grants[i].Grantee.URI = aws.String(g.URI)
+			}
+			if g.EmailAddress != "" {
+				grants[i].Grantee.EmailAddress = aws.String(g.EmailAddress)
+			}
+		}
+	}
+	if err := acl.Validate(grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// putACL takes the new ACL of Put*Acl from the headers or else the body.
+func putACL(r *http.Request) (*s3types.AccessControlPolicy, error) {
+	grants, err := requestGrants(r)
+	if err != nil {
+		return nil, err
+	}
+	if grants == nil {
+		if grants, err = decodeAccessControlPolicy(r); err != nil {
+			return nil, err
+		}
+	}
+	return &s3types.AccessControlPolicy{Grants: grants}, nil
+}
+
+// writeACL answers Get*Acl with the stored owner, grants not stored yet are
+// the private ACL of the owner. A bucket created anonymously has no owner and
+// is reported as owned by anonymous, see ownedBy.
+func writeACL(wr http.ResponseWriter, r *http.Request, stored *s3types.Owner, grants []s3types.Grant) {
+	owner := types.Owner{ID: "anonymous", DisplayName: "anonymous"}
+	if stored != nil {
+		owner = types.Owner{ID: aws.ToString(stored.ID), DisplayName: aws.ToString(stored.DisplayName)}
+	}
+	if len(grants) == 0 {
+		grants = []s3types.Grant{acl.UserGrant(owner.ID, owner.DisplayName, s3types.PermissionFullControl)}
+	}
+	result := &types.AccessControlPolicy{
+		Xmlns:  types.S3Namespace,
+		Owner:  &owner,
+		Grants: make([]types.Grant, len(grants)),
+	}
+	for i, grant := range grants {
+		result.Grants[i] = types.Grant{
+			Grantee: &types.Grantee{
+				XmlnsXSI:    types.XSINamespace,
+				Type:        string(grant.Grantee.Type),
+				ID:          aws.ToString(grant.Grantee.ID),
+				DisplayName: aws.ToString(grant.Grantee.DisplayName),
+				URI:         aws.ToString(grant.Grantee.URI),
+			},
+			Permission: string(grant.Permission),
+		}
+	}
+	writeXML(wr, r, result)
+}
+
+func (a *S3Proxy) PutBucketAcl(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	policy, err := putACL(r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	_, err = a.Backend.PutBucketAcl(&s3.PutBucketAclInput{
+		Bucket:              aws.String(s3query.DstObj.Bucket),
+		AccessControlPolicy: policy,
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+}
+
+func (a *S3Proxy) GetBucketAcl(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	writeACL(wr, r, out.Owner, out.Grants)
+}
+
+func (a *S3Proxy) PutObjectAcl(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	policy, err := putACL(r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	input := &s3.PutObjectAclInput{
+		Bucket:              aws.String(s3query.DstObj.Bucket),
+		Key:                 aws.String(s3query.DstObj.Key),
+		AccessControlPolicy: policy,
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	if _, err := a.Backend.PutObjectAcl(input); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+}
+
+func (a *S3Proxy) GetObjectAcl(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	input := &s3.GetObjectAclInput{
+		Bucket: aws.String(s3query.DstObj.Bucket),
+		Key:    aws.String(s3query.DstObj.Key),
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	out, err := a.Backend.GetObjectAcl(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	owner := out.Owner
+	if owner == nil {
+		// Objects written anonymously belong to the bucket owner.
+		bucket, err := a.Backend.GetBucketAcl(&s3.GetBucketAclInput{Bucket: input.Bucket})
+		if err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+		owner = bucket.Owner
+	}
+	writeACL(wr, r, owner, out.Grants)
+}
+
+// aclPermission is the ACL permission standing in for an IAM action, on the
+// object itself or on its bucket.
+type aclPermission struct {
+	permission s3types.Permission
+	object     bool
+}
+
+// aclPermissions lists the actions an ACL can grant, the others are left to
+// the bucket owner.
+var aclPermissions = map[string]aclPermission{
+	"s3:GetObject":                  {s3types.PermissionRead, true},
+	"s3:GetObjectVersion":           {s3types.PermissionRead, true},
+	"s3:GetObjectAcl":               {s3types.PermissionReadAcp, true},
+	"s3:GetObjectVersionAcl":        {s3types.PermissionReadAcp, true},
+	"s3:PutObjectAcl":               {s3types.PermissionWriteAcp, true},
+	"s3:PutObjectVersionAcl":        {s3types.PermissionWriteAcp, true},
+	"s3:ListBucket":                 {s3types.PermissionRead, false},
+	"s3:ListBucketVersions":         {s3types.PermissionRead, false},
+	"s3:ListBucketMultipartUploads": {s3types.PermissionRead, false},
+	"s3:PutObject":                  {s3types.PermissionWrite, false},
+	"s3:DeleteObject":               {s3types.PermissionWrite, false},
+	"s3:DeleteObjectVersion":        {s3types.PermissionWrite, false},
+	"s3:AbortMultipartUpload":       {s3types.PermissionWrite, false},
+	"s3:ListMultipartUploadParts":   {s3types.PermissionWrite, false},
+	"s3:GetBucketAcl":               {s3types.PermissionReadAcp, false},
+	"s3:PutBucketAcl":               {s3types.PermissionWriteAcp, false},
+}
+
+// ownedBy reports whether requester owns a bucket with owner. A bucket
+// created anonymously, before authentication was enabled, has no owner and
+// is owned by nobody: only its grants and policy open it.
+func ownedBy(owner *s3types.Owner, requester string) bool {
+	return requester != "" && owner != nil && aws.ToString(owner.ID) == requester
+}
+
+// aclAllows reports whether the requester may perform action on obj as the
+// owner of its bucket or of the object itself, or through a grant of their
+// ACLs. Authenticated requesters may create any bucket and are let through
+// to the NoSuchBucket of a missing one, anonymous requesters are not.
+func (a *S3Proxy) aclAllows(r *http.Request, obj types.S3Object, action string) (bool, error) {
+	if obj.Bucket == "" {
+		return false, nil
+	}
+	var requester string
+	if cred := auth.FromContext(r.Context()); cred != nil {
+		requester = cred.ID()
+	}
+	if action == "s3:CreateBucket" {
+		return requester != "", nil
+	}
+	bucket, err := a.Backend.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(obj.Bucket)})
+	if err != nil {
+		if s3error.CodeOf(err) == s3error.ErrorCodeNoSuchBucket && requester != "" {
+			return true, nil
+		}
+		return false, ignoreMissing(err)
+	}
+	if ownedBy(bucket.Owner, requester) {
+		return true, nil
+	}
+	p, ok := aclPermissions[action]
+	if !ok {
+		return false, nil
+	}
+	if !p.object {
+		return acl.Allows(bucket.Grants, requester, p.permission), nil
+	}
+	input := &s3.GetObjectAclInput{Bucket: aws.String(obj.Bucket), Key: aws.String(obj.Key)}
+	if obj.VersionId != "" {
+		input.VersionId = aws.String(obj.VersionId)
+	}
+	out, err := a.Backend.GetObjectAcl(input)
+	if err != nil {
+		return false, ignoreMissing(err)
+	}
+	if requester != "" && out.Owner != nil && aws.ToString(out.Owner.ID) == requester {
+		return true, nil
+	}
+	return acl.Allows(out.Grants, requester, p.permission), nil
+}
+
+// ignoreMissing drops the errors of looking up an ACL that does not exist,
+// the requester learns no more than AccessDenied.
+func ignoreMissing(err error) error {
+	switch s3error.CodeOf(err) {
+	case s3error.ErrorCodeNoSuchBucket, s3error.ErrorCodeNoSuchKey,
+		s3error.ErrorCodeNoSuchVersion, s3error.ErrorCodeMethodNotAllowed:
+		return nil
+	}
+	return err
+}
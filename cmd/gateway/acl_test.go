@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/acl"
+	"github.com/dashjay/overlay_oss/pkg/auth"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/backend/sqlite"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// owner names id as the owner of a resource, none when id is empty.
+func owner(id string) *s3types.Owner {
+	if id == "" {
+		return nil
+	}
+	return &s3types.Owner{ID: aws.String(id), DisplayName: aws.String(id)}
+}
+
+// testProxy serves a fresh backend holding:
+//   - "shared", owned by alice, readable by bob and writable by every
+//     authenticated user
+//   - "shared/alice.txt", readable by carol and whose ACL anyone may read
+//   - "shared/bob.txt", written by bob
+//   - "legacy", created anonymously before authentication was enabled
+func testProxy(t *testing.T) *S3Proxy {
+	t.Helper()
+	dir := t.TempDir()
+	be, err := sqlite.New(filepath.Join(dir, "test.db"), filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	be.DB = be.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	mustCreate := func(name, ownerID string, grants s3.CreateBucketInput) {
+		grants.Bucket = aws.String(name)
+		if _, err := be.CreateBucket(&backend.CreateBucketInput{CreateBucketInput: grants, Owner: owner(ownerID)}); err != nil {
+			t.Fatalf("CreateBucket %s: %v", name, err)
+		}
+	}
+	mustPut := func(key, ownerID string, grants s3.PutObjectInput) {
+		grants.Bucket, grants.Key = aws.String("shared"), aws.String(key)
+		grants.Body, grants.ContentLength = strings.NewReader(key), int64(len(key))
+		if _, err := be.PutObject(&backend.PutObjectInput{PutObjectInput: grants, Owner: owner(ownerID)}); err != nil {
+			t.Fatalf("PutObject %s: %v", key, err)
+		}
+	}
+	mustCreate("shared", "alice", s3.CreateBucketInput{
+		GrantFullControl: aws.String(`id="alice"`),
+		GrantRead:        aws.String(`id="bob"`),
+		GrantWrite:       aws.String(`uri="` + acl.AuthenticatedUsers + `"`),
+	})
+	mustCreate("legacy", "", s3.CreateBucketInput{})
+	mustPut("alice.txt", "alice", s3.PutObjectInput{
+		GrantRead:    aws.String(`id="carol"`),
+		GrantReadACP: aws.String(`uri="` + acl.AllUsers + `"`),
+	})
+	mustPut("bob.txt", "bob", s3.PutObjectInput{})
+	a := NewS3Proxy(be)
+	a.Auth = auth.NewVerifier(auth.StaticStore{})
+	return a
+}
+
+// requestAs is a request authenticated as id, anonymous when id is empty.
+func requestAs(id string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if id != "" {
+		r = r.WithContext(auth.WithCredential(r.Context(), &auth.Credential{AccessKey: id, DisplayName: id}))
+	}
+	return r
+}
+
+func TestACLAllows(t *testing.T) {
+	a := testProxy(t)
+	tests := []struct {
+		name      string
+		requester string
+		obj       types.S3Object
+		action    string
+		want      bool
+	}{
+		{"bucket owner", "alice", types.S3Object{Bucket: "shared"}, "s3:PutBucketVersioning", true},
+		{"owner only action", "bob", types.S3Object{Bucket: "shared"}, "s3:PutBucketVersioning", false},
+		{"bucket user grant", "bob", types.S3Object{Bucket: "shared"}, "s3:ListBucket", true},
+		{"no bucket grant", "carol", types.S3Object{Bucket: "shared"}, "s3:ListBucket", false},
+		{"bucket group grant", "carol", types.S3Object{Bucket: "shared", Key: "new"}, "s3:PutObject", true},
+		{"group excludes anonymous", "", types.S3Object{Bucket: "shared", Key: "new"}, "s3:PutObject", false},
+		{"object user grant", "carol", types.S3Object{Bucket: "shared", Key: "alice.txt"}, "s3:GetObject", true},
+		{"bucket read is no object read", "bob", types.S3Object{Bucket: "shared", Key: "alice.txt"}, "s3:GetObject", false},
+		{"object grant to all users", "", types.S3Object{Bucket: "shared", Key: "alice.txt"}, "s3:GetObjectAcl", true},
+		{"object grant is per permission", "", types.S3Object{Bucket: "shared", Key: "alice.txt"}, "s3:GetObject", false},
+		{"object owner reads", "bob", types.S3Object{Bucket: "shared", Key: "bob.txt"}, "s3:GetObject", true},
+		{"object owner rewrites acl", "bob", types.S3Object{Bucket: "shared", Key: "bob.txt"}, "s3:PutObjectAcl", true},
+		{"bucket owner reads any object", "alice", types.S3Object{Bucket: "shared", Key: "bob.txt"}, "s3:GetObject", true},
+		{"other user", "carol", types.S3Object{Bucket: "shared", Key: "bob.txt"}, "s3:GetObject", false},
+		{"bucket owner on missing key", "alice", types.S3Object{Bucket: "shared", Key: "missing"}, "s3:GetObject", true},
+		{"other user on missing key", "carol", types.S3Object{Bucket: "shared", Key: "missing"}, "s3:GetObject", false},
+		{"authenticated on missing bucket", "carol", types.S3Object{Bucket: "missing"}, "s3:ListBucket", true},
+		{"anonymous on missing bucket", "", types.S3Object{Bucket: "missing"}, "s3:ListBucket", false},
+		{"authenticated creates bucket", "carol", types.S3Object{Bucket: "shared"}, "s3:CreateBucket", true},
+		{"anonymous creates bucket", "", types.S3Object{Bucket: "new"}, "s3:CreateBucket", false},
+		{"ownerless bucket", "carol", types.S3Object{Bucket: "legacy"}, "s3:PutBucketVersioning", false},
+		{"ownerless bucket second credential", "bob", types.S3Object{Bucket: "legacy"}, "s3:ListBucket", false},
+		{"ownerless bucket anonymous", "", types.S3Object{Bucket: "legacy"}, "s3:ListBucket", false},
+		{"no bucket", "alice", types.S3Object{}, "s3:ListBucket", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.aclAllows(requestAs(tt.requester), tt.obj, tt.action)
+			if err != nil {
+				t.Fatalf("aclAllows: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("aclAllows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	a := testProxy(t)
+	_, err := a.Backend.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String("shared"),
+		Policy: aws.String(`{"Statement": [
+			{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::shared/alice.txt"},
+			{"Effect": "Allow", "Principal": {"AWS": "carol"}, "Action": "s3:PutBucketTagging", "Resource": "arn:aws:s3:::shared"}
+		]}`),
+	})
+	if err != nil {
+		t.Fatalf("PutBucketPolicy: %v", err)
+	}
+	tests := []struct {
+		name      string
+		requester string
+		obj       types.S3Object
+		action    string
+		allowed   bool
+	}{
+		{"deny overrides ownership", "alice", types.S3Object{Bucket: "shared", Key: "alice.txt"}, "s3:GetObject", false},
+		{"deny overrides grant", "carol", types.S3Object{Bucket: "shared", Key: "alice.txt"}, "s3:GetObject", false},
+		{"allow without grant", "carol", types.S3Object{Bucket: "shared"}, "s3:PutBucketTagging", true},
+		{"no statement falls back to owner", "alice", types.S3Object{Bucket: "shared"}, "s3:PutBucketTagging", true},
+		{"no statement nor grant", "bob", types.S3Object{Bucket: "shared"}, "s3:PutBucketTagging", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.checkAccess(requestAs(tt.requester), tt.obj, tt.action)
+			if tt.allowed && err != nil {
+				t.Fatalf("checkAccess: %v", err)
+			}
+			if !tt.allowed && s3error.CodeOf(err) != s3error.ErrorCodeAccessDenied {
+				t.Fatalf("checkAccess = %v, want AccessDenied", err)
+			}
+		})
+	}
+	a.Auth = nil
+	if err := a.checkAccess(requestAs(""), types.S3Object{Bucket: "shared"}, "s3:PutBucketTagging"); err != nil {
+		t.Fatalf("checkAccess without authentication: %v", err)
+	}
+}
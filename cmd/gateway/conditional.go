@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// preconditions are the conditional headers of a request. CopyObject carries
+// the same set prefixed with x-amz-copy-source- for its source object.
+type preconditions struct {
+	ifMatch           string
+	ifNoneMatch       string
+	ifModifiedSince   string
+	ifUnmodifiedSince string
+}
+
+func requestPreconditions(h http.Header, prefix string) preconditions {
+	return preconditions{
+		ifMatch:           h.Get(prefix + "If-Match"),
+		ifNoneMatch:       h.Get(prefix + "If-None-Match"),
+		ifModifiedSince:   h.Get(prefix + "If-Modified-Since"),
+		ifUnmodifiedSince: h.Get(prefix + "If-Unmodified-Since"),
+	}
+}
+
+// evaluate applies the S3 rules against the current object and returns 0 to
+// proceed, http.StatusPreconditionFailed or http.StatusNotModified. A
+// matching If-Match overrides If-Unmodified-Since and a non-matching
+// If-None-Match overrides If-Modified-Since.
+func (p preconditions) evaluate(etag string, lastModified time.Time) int {
+	lastModified = lastModified.Truncate(time.Second)
+	if p.ifMatch != "" {
+		if !etagListMatches(p.ifMatch, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPTime(p.ifUnmodifiedSince); ok && lastModified.After(since) {
+		return http.StatusPreconditionFailed
+	}
+	if p.ifNoneMatch != "" {
+		if etagListMatches(p.ifNoneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if since, ok := parseHTTPTime(p.ifModifiedSince); ok && !lastModified.After(since) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagListMatches reports whether etag is in a comma separated If-Match
+// style list, "*" matches any existing object.
+func etagListMatches(list string, etag string) bool {
+	etag = strings.Trim(etag, "\"")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.Trim(strings.TrimPrefix(candidate, "W/"), "\"")
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPTime(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+const copySourcePrefix = "x-amz-copy-source-"
+
+// copySource heads the source object of a copy and applies the
+// x-amz-copy-source-if-* preconditions to it. Unlike GET, every failed
+// condition of a copy answers 412.
+func (a *S3Proxy) copySource(s3query types.S3Query, r *http.Request) (*s3.HeadObjectOutput, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s3query.SrcObj.Bucket),
+		Key:    aws.String(s3query.SrcObj.Key),
+	}
+	if s3query.SrcObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.SrcObj.VersionId)
+	}
+	src, err := a.Backend.HeadObject(input)
+	if err != nil {
+		return nil, err
+	}
+	if requestPreconditions(r.Header, copySourcePrefix).evaluate(aws.ToString(src.ETag), aws.ToTime(src.LastModified)) != 0 {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodePreconditionFailed}
+	}
+	return src, nil
+}
+
+// copySourcePath renders src the way Backend.CopyObject expects it.
+func copySourcePath(src types.S3Object) string {
+	path := src.Bucket + "/" + src.Key
+	if src.VersionId != "" {
+		path += "?versionId=" + src.VersionId
+	}
+	return path
+}
+
+// setCopyHeaders reports the versions a copy read and wrote.
+func setCopyHeaders(h http.Header, sourceVersionId, versionId *string) {
+	if sourceVersionId != nil {
+		h.Set("x-amz-copy-source-version-id", aws.ToString(sourceVersionId))
+	}
+	if versionId != nil {
+		h.Set("x-amz-version-id", aws.ToString(versionId))
+	}
+}
+
+func (a *S3Proxy) CopyObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	src, err := a.copySource(s3query, r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	meta, err := requestMeta(r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	input := &backend.CopyObjectInput{
+		CopyObjectInput: s3.CopyObjectInput{
+			Bucket:            aws.String(s3query.DstObj.Bucket),
+			Key:               aws.String(s3query.DstObj.Key),
+			CopySource:        aws.String(copySourcePath(s3query.SrcObj)),
+			CopySourceIfMatch: src.ETag,
+			MetadataDirective: s3types.MetadataDirective(r.Header.Get("x-amz-metadata-directive")),
+			TaggingDirective:  s3types.TaggingDirective(r.Header.Get("x-amz-tagging-directive")),
+		},
+		Owner: resourceOwner(r),
+	}
+	meta.copyGrants(&input.CopyObjectInput)
+	if input.MetadataDirective == s3types.MetadataDirectiveReplace {
+		meta.copyObjectInput(&input.CopyObjectInput)
+	}
+	if input.TaggingDirective == s3types.TaggingDirectiveReplace {
+		input.Tagging = meta.tagging
+	}
+	out, err := a.Backend.CopyObject(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	setCopyHeaders(wr.Header(), out.CopySourceVersionId, out.VersionId)
+	writeXML(wr, r, &types.CopyObjectResult{
+		LastModified: aws.ToTime(out.CopyObjectResult.LastModified).UTC(),
+		ETag:         aws.ToString(out.CopyObjectResult.ETag),
+	})
+}
+
+func (a *S3Proxy) UploadPartCopy(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	src, err := a.copySource(s3query, r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	input := &s3.UploadPartCopyInput{
+		Bucket:            aws.String(s3query.DstObj.Bucket),
+		Key:               aws.String(s3query.DstObj.Key),
+		UploadId:          aws.String(s3query.MpQuery.UploadId),
+		PartNumber:        int32(s3query.MpQuery.PartNumber),
+		CopySource:        aws.String(copySourcePath(s3query.SrcObj)),
+		CopySourceIfMatch: src.ETag,
+	}
+	if v := r.Header.Get("x-amz-copy-source-range"); v != "" {
+		input.CopySourceRange = aws.String(v)
+	}
+	out, err := a.Backend.UploadPartCopy(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	setCopyHeaders(wr.Header(), out.CopySourceVersionId, nil)
+	writeXML(wr, r, &types.CopyPartResult{
+		LastModified: aws.ToTime(out.CopyPartResult.LastModified).UTC(),
+		ETag:         aws.ToString(out.CopyPartResult.ETag),
+	})
+}
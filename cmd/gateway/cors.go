@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+const (
+	maxCORSRules = 100
+	// maxCORSBody bounds the CORSConfiguration document.
+	maxCORSBody = 64 << 10
+)
+
+// corsMethods are the methods a CORS rule may allow.
+var corsMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPut:    true,
+	http.MethodHead:   true,
+	http.MethodPost:   true,
+	http.MethodDelete: true,
+}
+
+func corsError(code s3error.ErrorCode, format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: code}
+}
+
+// corsRule validates rule. Origins and headers may hold one wildcard each.
+func corsRule(rule *types.CORSRule) (s3types.CORSRule, error) {
+	out := s3types.CORSRule{
+		AllowedHeaders: rule.AllowedHeaders,
+		AllowedMethods: rule.AllowedMethods,
+		AllowedOrigins: rule.AllowedOrigins,
+		ExposeHeaders:  rule.ExposeHeaders,
+		MaxAgeSeconds:  rule.MaxAgeSeconds,
+	}
+	if rule.ID != "" {
+		if len(rule.ID) > 255 {
+			return out, corsError(s3error.ErrorCodeInvalidArgument, "ID length should not exceed allowed limit of 255")
+		}
+		out.ID = aws.String(rule.ID)
+	}
+	if len(rule.AllowedMethods) == 0 || len(rule.AllowedOrigins) == 0 {
+		return out, corsError(s3error.ErrorCodeMalformedXML, "a CORSRule needs at least one AllowedMethod and AllowedOrigin")
+	}
+	for _, method := range rule.AllowedMethods {
+		if !corsMethods[method] {
+			return out, corsError(s3error.ErrorCodeInvalidRequest, "found unsupported HTTP method in CORS config. Unsupported method is %s", method)
+		}
+	}
+	for _, origin := range rule.AllowedOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return out, corsError(s3error.ErrorCodeInvalidRequest, "AllowedOrigin \"%s\" can not have more than one wildcard", origin)
+		}
+	}
+	for _, header := range rule.AllowedHeaders {
+		if strings.Count(header, "*") > 1 {
+			return out, corsError(s3error.ErrorCodeInvalidRequest, "AllowedHeader \"%s\" can not have more than one wildcard", header)
+		}
+	}
+	if rule.MaxAgeSeconds < 0 {
+		return out, corsError(s3error.ErrorCodeInvalidArgument, "MaxAgeSeconds must not be negative")
+	}
+	return out, nil
+}
+
+// wildcardMatch matches s against pattern, in which a single '*' stands for
+// any run of characters.
+func wildcardMatch(pattern, s string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == s
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(s) >= len(prefix)+len(suffix) && strings.HasPrefix(s, prefix) && strings.HasSuffix(s, suffix)
+}
+
+func anyMatch(patterns []string, s string, fold bool) bool {
+	for _, pattern := range patterns {
+		if fold {
+			pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+		}
+		if wildcardMatch(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCORSRule returns the first rule admitting a request of method from
+// origin that sends headers, rules are tried in their configured order.
+func matchCORSRule(rules []s3types.CORSRule, origin, method string, headers []string) *s3types.CORSRule {
+	for i := range rules {
+		rule := &rules[i]
+		if !anyMatch(rule.AllowedOrigins, origin, false) {
+			continue
+		}
+		allowed := false
+		for _, m := range rule.AllowedMethods {
+			allowed = allowed || m == method
+		}
+		for _, header := range headers {
+			allowed = allowed && anyMatch(rule.AllowedHeaders, header, true)
+		}
+		if allowed {
+			return rule
+		}
+	}
+	return nil
+}
+
+// corsRules loads the rules of bucket, a missing bucket or configuration
+// has none.
+func (a *S3Proxy) corsRules(bucket string) ([]s3types.CORSRule, error) {
+	out, err := a.Backend.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		switch s3error.CodeOf(err) {
+		case s3error.ErrorCodeNoSuchCORSConfiguration, s3error.ErrorCodeNoSuchBucket:
+			return nil, nil
+		}
+		return nil, err
+	}
+	return out.CORSRules, nil
+}
+
+// setCORSHeaders grants origin access according to rule. A rule allowing
+// every origin answers "*" and does not allow credentials.
+func setCORSHeaders(h http.Header, rule *s3types.CORSRule, origin string) {
+	wildcard := false
+	for _, o := range rule.AllowedOrigins {
+		wildcard = wildcard || o == "*"
+	}
+	if wildcard {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(rule.MaxAgeSeconds)))
+	}
+	h.Add("Vary", "Origin")
+}
+
+// applyCORS adds the CORS headers of the first rule matching a request
+// carrying Origin, before it is authorized so errors are readable too.
+func (a *S3Proxy) applyCORS(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s3query.DstObj.Bucket == "" {
+		return
+	}
+	rules, err := a.corsRules(s3query.DstObj.Bucket)
+	if err != nil {
+		return
+	}
+	if rule := matchCORSRule(rules, origin, r.Method, nil); rule != nil {
+		setCORSHeaders(wr.Header(), rule, origin)
+	}
+}
+
+// PreflightObject answers a browser's OPTIONS request from the CORS rules
+// of the bucket, without authentication.
+func (a *S3Proxy) PreflightObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		s3error.WriteError(r, wr, corsError(s3error.ErrorCodeInvalidRequest, "insufficient information. Origin and Access-Control-Request-Method request headers needed"))
+		return
+	}
+	var headers []string
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+	rules, err := a.corsRules(s3query.DstObj.Bucket)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	rule := matchCORSRule(rules, origin, method, headers)
+	if rule == nil {
+		s3error.WriteError(r, wr, corsError(s3error.ErrorCodeAccessDenied, "CORSResponse: This CORS request is not allowed"))
+		return
+	}
+	h := wr.Header()
+	setCORSHeaders(h, rule, origin)
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	wr.WriteHeader(http.StatusOK)
+}
+
+func (a *S3Proxy) PutBucketCors(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCORSBody+1))
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if len(body) > maxCORSBody {
+		s3error.WriteError(r, wr, corsError(s3error.ErrorCodeMalformedXML, "CORS configuration exceeds %d bytes", maxCORSBody))
+		return
+	}
+	if err := checkContentMD5(r.Header, body); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	var config types.CORSConfiguration
+	if err := xml.Unmarshal(body, &config); err != nil {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedXML})
+		return
+	}
+	if len(config.Rules) == 0 || len(config.Rules) > maxCORSRules {
+		s3error.WriteError(r, wr, corsError(s3error.ErrorCodeMalformedXML, "a CORS configuration holds between 1 and %d rules", maxCORSRules))
+		return
+	}
+	rules := make([]s3types.CORSRule, len(config.Rules))
+	for i := range config.Rules {
+		if rules[i], err = corsRule(&config.Rules[i]); err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+	}
+	_, err = a.Backend.PutBucketCors(&s3.PutBucketCorsInput{
+		Bucket:            aws.String(s3query.DstObj.Bucket),
+		CORSConfiguration: &s3types.CORSConfiguration{CORSRules: rules},
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+}
+
+func (a *S3Proxy) GetBucketCors(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	result := &types.CORSConfiguration{Xmlns: types.S3Namespace, Rules: make([]types.CORSRule, len(out.CORSRules))}
+	for i, rule := range out.CORSRules {
+		result.Rules[i] = types.CORSRule{
+			ID:             aws.ToString(rule.ID),
+			AllowedHeaders: rule.AllowedHeaders,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedOrigins: rule.AllowedOrigins,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  rule.MaxAgeSeconds,
+		}
+	}
+	writeXML(wr, r, result)
+}
+
+func (a *S3Proxy) DeleteBucketCors(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.DeleteBucketCors(&s3.DeleteBucketCorsInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+const (
+	maxDeleteKeys = 1000
+	// maxDeleteBody bounds the Delete document, 1000 keys of up to 1024
+	// bytes each plus markup.
+	maxDeleteBody = 2 << 20
+)
+
+// checkContentMD5 compares a Content-MD5 header, when sent, against body.
+func checkContentMD5(h http.Header, body []byte) error {
+	v := h.Get("Content-MD5")
+	if v == "" {
+		return nil
+	}
+	expected, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(expected) != md5.Size {
+		return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidDigest}
+	}
+	if sum := md5.Sum(body); !bytes.Equal(sum[:], expected) {
+		return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeBadDigest}
+	}
+	return nil
+}
+
+// checksums computes the x-amz-checksum-* digests a body can be checked
+// against, keyed by header name.
+var checksums = map[string]func(body []byte) []byte{
+	"X-Amz-Checksum-Crc32": func(body []byte) []byte {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(body))
+		return sum
+	},
+	"X-Amz-Checksum-Crc32c": func(body []byte) []byte {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)))
+		return sum
+	},
+	"X-Amz-Checksum-Sha1": func(body []byte) []byte {
+		sum := sha1.Sum(body)
+		return sum[:]
+	},
+	"X-Amz-Checksum-Sha256": func(body []byte) []byte {
+		sum := sha256.Sum256(body)
+		return sum[:]
+	},
+}
+
+// requireContentMD5 is checkContentMD5 for requests S3 rejects without an
+// integrity header: Content-MD5 or an x-amz-checksum-* header must be sent.
+func requireContentMD5(h http.Header, body []byte) error {
+	if h.Get("Content-MD5") != "" {
+		return checkContentMD5(h, body)
+	}
+	for name, values := range h {
+		if !strings.HasPrefix(name, "X-Amz-Checksum-") || name == "X-Amz-Checksum-Algorithm" || name == "X-Amz-Checksum-Type" {
+			continue
+		}
+		checksum, ok := checksums[name]
+		if !ok {
+			return s3error.S3Error{
+				OriginError: fmt.Errorf("unsupported checksum header %s", name),
+				Code:        s3error.ErrorCodeInvalidRequest,
+			}
+		}
+		expected, err := base64.StdEncoding.DecodeString(values[0])
+		if err != nil {
+			return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidDigest}
+		}
+		if !bytes.Equal(checksum(body), expected) {
+			return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeBadDigest}
+		}
+		return nil
+	}
+	return s3error.S3Error{
+		OriginError: fmt.Errorf("Missing required header for this request: Content-MD5"),
+		Code:        s3error.ErrorCodeInvalidRequest,
+	}
+}
+
+// DeleteObjects removes up to 1000 keys in one request. A missing key counts
+// as deleted, as it does for DeleteObject; Quiet leaves successes out of the
+// result.
+func (a *S3Proxy) DeleteObjects(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	bucket := s3query.DstObj.Bucket
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxDeleteBody+1))
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if len(body) > maxDeleteBody {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("delete request body exceeds %d bytes", maxDeleteBody),
+			Code:        s3error.ErrorCodeMalformedXML,
+		})
+		return
+	}
+	if err := requireContentMD5(r.Header, body); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	var req types.DeleteRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedXML})
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteKeys {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("a delete request must name between 1 and %d keys", maxDeleteKeys),
+			Code:        s3error.ErrorCodeMalformedXML,
+		})
+		return
+	}
+	// Access is checked before the bucket is looked up, so requesters
+	// denied every key cannot tell whether the bucket exists.
+	denied := make([]error, len(req.Objects))
+	allowed := false
+	for i, obj := range req.Objects {
+		target := types.S3Object{Bucket: bucket, Key: obj.Key, VersionId: obj.VersionId}
+		denied[i] = a.checkAccess(r, target, objectAction("s3:DeleteObject", target))
+		allowed = allowed || denied[i] == nil
+	}
+	if allowed {
+		if _, err := a.Backend.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+	}
+	result := &types.DeleteResult{}
+	for i, obj := range req.Objects {
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(obj.Key),
+		}
+		if obj.VersionId != "" {
+			input.VersionId = aws.String(obj.VersionId)
+		}
+		err := denied[i]
+		var out *s3.DeleteObjectOutput
+		if err == nil {
+			out, err = a.Backend.DeleteObject(input)
+		}
+		if err != nil && !s3error.IsNoSuchKey(err) {
+			result.Errors = append(result.Errors, types.DeleteError{
+				Key:       obj.Key,
+				VersionId: obj.VersionId,
+				Code:      string(s3error.CodeOf(err)),
+				Message:   err.Error(),
+			})
+			continue
+		}
+		if req.Quiet {
+			continue
+		}
+		deleted := types.DeletedObject{Key: obj.Key, VersionId: obj.VersionId}
+		if out != nil && out.DeleteMarker {
+			deleted.DeleteMarker = true
+			if obj.VersionId == "" {
+				// A new delete marker was written rather than a version removed.
+				deleted.DeleteMarkerVersionId = aws.ToString(out.VersionId)
+			}
+		}
+		result.Deleted = append(result.Deleted, deleted)
+	}
+	writeXML(wr, r, result)
+}
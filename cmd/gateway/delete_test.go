@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func TestRequireContentMD5(t *testing.T) {
+	body := []byte("<Delete><Object><Key>a</Key></Object></Delete>")
+	md5sum := md5.Sum(body)
+	shasum := sha256.Sum256(body)
+	tests := []struct {
+		name   string
+		header map[string]string
+		code   s3error.ErrorCode
+	}{
+		{name: "content md5", header: map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(md5sum[:])}},
+		{name: "bad content md5", header: map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(shasum[:16])}, code: s3error.ErrorCodeBadDigest},
+		{name: "sha256 checksum", header: map[string]string{"X-Amz-Checksum-Sha256": base64.StdEncoding.EncodeToString(shasum[:])}},
+		{name: "crc32 checksum", header: map[string]string{"X-Amz-Checksum-Crc32": "AAAAAA=="}, code: s3error.ErrorCodeBadDigest},
+		{name: "algorithm alone", header: map[string]string{"X-Amz-Checksum-Algorithm": "CRC32"}, code: s3error.ErrorCodeInvalidRequest},
+		{name: "neither", code: s3error.ErrorCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			err := requireContentMD5(h, body)
+			var code s3error.ErrorCode
+			if err != nil {
+				code = s3error.CodeOf(err)
+			}
+			if code != tt.code {
+				t.Fatalf("requireContentMD5 = %v, want code %q", err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+const (
+	maxLifecycleRules  = 1000
+	maxLifecycleRuleID = 255
+	// maxLifecycleBody bounds the LifecycleConfiguration document.
+	maxLifecycleBody = 2 << 20
+)
+
+func lifecycleError(code s3error.ErrorCode, format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: code}
+}
+
+func lifecycleTags(tags []types.Tag) []s3types.Tag {
+	tagSet := make([]s3types.Tag, len(tags))
+	for i, tag := range tags {
+		tagSet[i] = s3types.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)}
+	}
+	return tagSet
+}
+
+// lifecycleFilter validates the scope of rule. Rules name either the rule
+// level Prefix or a Filter holding at most one of its members.
+func lifecycleFilter(rule *types.LifecycleRule, out *s3types.LifecycleRule) (hasTags bool, err error) {
+	if rule.Prefix != nil && rule.Filter != nil {
+		return false, lifecycleError(s3error.ErrorCodeMalformedXML, "a rule cannot have both Prefix and Filter")
+	}
+	if rule.Filter == nil {
+		if rule.Prefix == nil {
+			return false, lifecycleError(s3error.ErrorCodeMalformedXML, "a rule needs a Filter or a Prefix")
+		}
+		out.Prefix = aws.String(*rule.Prefix)
+		return false, nil
+	}
+	filter := rule.Filter
+	if filter.ObjectSizeGreaterThan != 0 || filter.ObjectSizeLessThan != 0 ||
+		(filter.And != nil && (filter.And.ObjectSizeGreaterThan != 0 || filter.And.ObjectSizeLessThan != 0)) {
+		return false, lifecycleError(s3error.ErrorCodeNotImplemented, "object size filters are not supported")
+	}
+	members := 0
+	for _, set := range []bool{filter.Prefix != nil, filter.Tag != nil, filter.And != nil} {
+		if set {
+			members++
+		}
+	}
+	if members > 1 {
+		return false, lifecycleError(s3error.ErrorCodeMalformedXML, "a Filter holds only one of Prefix, Tag and And")
+	}
+	switch {
+	case filter.Tag != nil:
+		if err := validateTags([]types.Tag{*filter.Tag}, maxObjectTags); err != nil {
+			return false, err
+		}
+		out.Filter = &s3types.LifecycleRuleFilterMemberTag{Value: lifecycleTags([]types.Tag{*filter.Tag})[0]}
+		return true, nil
+	case filter.And != nil:
+		// More tags than an object may carry could never match.
+		if err := validateTags(filter.And.Tags, maxObjectTags); err != nil {
+			return false, err
+		}
+		and := s3types.LifecycleRuleAndOperator{Tags: lifecycleTags(filter.And.Tags)}
+		if filter.And.Prefix != "" {
+			and.Prefix = aws.String(filter.And.Prefix)
+		}
+		out.Filter = &s3types.LifecycleRuleFilterMemberAnd{Value: and}
+		return len(filter.And.Tags) > 0, nil
+	default:
+		// An empty Filter matches every object, like an empty Prefix.
+		out.Filter = &s3types.LifecycleRuleFilterMemberPrefix{Value: aws.ToString(filter.Prefix)}
+		return false, nil
+	}
+}
+
+// lifecycleRule validates one rule of a PutBucketLifecycleConfiguration body.
+func lifecycleRule(rule *types.LifecycleRule) (s3types.LifecycleRule, error) {
+	out := s3types.LifecycleRule{Status: s3types.ExpirationStatus(rule.Status)}
+	if len(rule.ID) > maxLifecycleRuleID {
+		return out, lifecycleError(s3error.ErrorCodeInvalidArgument, "ID length should not exceed allowed limit of %d", maxLifecycleRuleID)
+	}
+	if rule.ID != "" {
+		out.ID = aws.String(rule.ID)
+	}
+	switch out.Status {
+	case s3types.ExpirationStatusEnabled, s3types.ExpirationStatusDisabled:
+	default:
+		return out, lifecycleError(s3error.ErrorCodeMalformedXML, "rule status must be Enabled or Disabled, got '%s'", rule.Status)
+	}
+	if len(rule.Transitions) > 0 || len(rule.NoncurrentVersionTransitions) > 0 {
+		return out, lifecycleError(s3error.ErrorCodeNotImplemented, "transitions are not supported")
+	}
+	hasTags, err := lifecycleFilter(rule, &out)
+	if err != nil {
+		return out, err
+	}
+	if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+		return out, lifecycleError(s3error.ErrorCodeInvalidRequest, "at least one action needs to be specified in a rule")
+	}
+	if exp := rule.Expiration; exp != nil {
+		actions := 0
+		for _, set := range []bool{exp.Days != 0, exp.Date != nil, exp.ExpiredObjectDeleteMarker != nil} {
+			if set {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return out, lifecycleError(s3error.ErrorCodeMalformedXML, "Expiration holds exactly one of Days, Date and ExpiredObjectDeleteMarker")
+		}
+		if exp.Days < 0 {
+			return out, lifecycleError(s3error.ErrorCodeInvalidArgument, "'Days' for Expiration action must be a positive integer")
+		}
+		if exp.Date != nil && !exp.Date.UTC().Truncate(24*time.Hour).Equal(*exp.Date) {
+			return out, lifecycleError(s3error.ErrorCodeInvalidArgument, "'Date' must be at midnight GMT")
+		}
+		if exp.ExpiredObjectDeleteMarker != nil && *exp.ExpiredObjectDeleteMarker && hasTags {
+			return out, lifecycleError(s3error.ErrorCodeInvalidRequest, "ExpiredObjectDeleteMarker cannot be specified with object tags")
+		}
+		out.Expiration = &s3types.LifecycleExpiration{
+			Days:                      exp.Days,
+			Date:                      exp.Date,
+			ExpiredObjectDeleteMarker: aws.ToBool(exp.ExpiredObjectDeleteMarker),
+		}
+	}
+	if exp := rule.NoncurrentVersionExpiration; exp != nil {
+		if exp.NoncurrentDays <= 0 {
+			return out, lifecycleError(s3error.ErrorCodeInvalidArgument, "'NoncurrentDays' for NoncurrentVersionExpiration action must be a positive integer")
+		}
+		if exp.NewerNoncurrentVersions < 0 {
+			return out, lifecycleError(s3error.ErrorCodeInvalidArgument, "'NewerNoncurrentVersions' must be a positive integer")
+		}
+		out.NoncurrentVersionExpiration = &s3types.NoncurrentVersionExpiration{
+			NoncurrentDays:          exp.NoncurrentDays,
+			NewerNoncurrentVersions: exp.NewerNoncurrentVersions,
+		}
+	}
+	if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+		if abort.DaysAfterInitiation <= 0 {
+			return out, lifecycleError(s3error.ErrorCodeInvalidArgument, "'DaysAfterInitiation' for AbortIncompleteMultipartUpload action must be a positive integer")
+		}
+		if hasTags {
+			return out, lifecycleError(s3error.ErrorCodeInvalidRequest, "tag-based filter cannot be used with AbortIncompleteMultipartUpload action")
+		}
+		out.AbortIncompleteMultipartUpload = &s3types.AbortIncompleteMultipartUpload{DaysAfterInitiation: abort.DaysAfterInitiation}
+	}
+	return out, nil
+}
+
+func (a *S3Proxy) PutBucketLifecycle(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxLifecycleBody+1))
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if len(body) > maxLifecycleBody {
+		s3error.WriteError(r, wr, lifecycleError(s3error.ErrorCodeMalformedXML, "lifecycle configuration exceeds %d bytes", maxLifecycleBody))
+		return
+	}
+	if err := checkContentMD5(r.Header, body); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	var config types.LifecycleConfiguration
+	if err := xml.Unmarshal(body, &config); err != nil {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedXML})
+		return
+	}
+	if len(config.Rules) == 0 || len(config.Rules) > maxLifecycleRules {
+		s3error.WriteError(r, wr, lifecycleError(s3error.ErrorCodeMalformedXML, "a lifecycle configuration holds between 1 and %d rules", maxLifecycleRules))
+		return
+	}
+	rules := make([]s3types.LifecycleRule, len(config.Rules))
+	ids := make(map[string]bool, len(config.Rules))
+	for i := range config.Rules {
+		if rules[i], err = lifecycleRule(&config.Rules[i]); err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+		if id := config.Rules[i].ID; id != "" {
+			if ids[id] {
+				s3error.WriteError(r, wr, lifecycleError(s3error.ErrorCodeInvalidArgument, "rule ID must be unique, found duplicate '%s'", id))
+				return
+			}
+			ids[id] = true
+		}
+	}
+	_, err = a.Backend.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(s3query.DstObj.Bucket),
+		LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+}
+
+func (a *S3Proxy) GetBucketLifecycle(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	result := &types.LifecycleConfiguration{Xmlns: types.S3Namespace, Rules: make([]types.LifecycleRule, len(out.Rules))}
+	for i, rule := range out.Rules {
+		item := types.LifecycleRule{ID: aws.ToString(rule.ID), Status: string(rule.Status), Prefix: rule.Prefix}
+		switch filter := rule.Filter.(type) {
+		case *s3types.LifecycleRuleFilterMemberPrefix:
+			item.Filter = &types.LifecycleFilter{Prefix: aws.String(filter.Value)}
+		case *s3types.LifecycleRuleFilterMemberTag:
+			item.Filter = &types.LifecycleFilter{Tag: &types.Tag{Key: aws.ToString(filter.Value.Key), Value: aws.ToString(filter.Value.Value)}}
+		case *s3types.LifecycleRuleFilterMemberAnd:
+			and := &types.LifecycleFilterAnd{Prefix: aws.ToString(filter.Value.Prefix)}
+			for _, tag := range filter.Value.Tags {
+				and.Tags = append(and.Tags, types.Tag{Key: aws.ToString(tag.Key), Value: aws.ToString(tag.Value)})
+			}
+			item.Filter = &types.LifecycleFilter{And: and}
+		}
+		if exp := rule.Expiration; exp != nil {
+			item.Expiration = &types.LifecycleExpiration{Days: exp.Days, Date: exp.Date}
+			if exp.ExpiredObjectDeleteMarker {
+				item.Expiration.ExpiredObjectDeleteMarker = aws.Bool(true)
+			}
+		}
+		if exp := rule.NoncurrentVersionExpiration; exp != nil {
+			item.NoncurrentVersionExpiration = &types.NoncurrentVersionExpiration{
+				NoncurrentDays:          exp.NoncurrentDays,
+				NewerNoncurrentVersions: exp.NewerNoncurrentVersions,
+			}
+		}
+		if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+			item.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: abort.DaysAfterInitiation}
+		}
+		result.Rules[i] = item
+	}
+	writeXML(wr, r, result)
+}
+
+func (a *S3Proxy) DeleteBucketLifecycle(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+const encodingTypeURL = "url"
+
+// s3URLEncode escapes a key for encoding-type=url responses. Like S3 it keeps
+// '/' readable and encodes spaces as %20.
+func s3URLEncode(s string) string {
+	return strings.NewReplacer("+", "%20", "%2F", "/").Replace(url.QueryEscape(s))
+}
+
+// GetBucket answers both ListObjects and ListObjectsV2, the backend pages by
+// key and the response shape follows the list-type the client asked for.
+func (a *S3Proxy) GetBucket(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	lq := s3query.ListQuery
+	if lq.EncodingType != "" && lq.EncodingType != encodingTypeURL {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("invalid Encoding Method specified in Request"),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		})
+		return
+	}
+	if lq.MaxKeys < 0 {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("argument max-keys must be an integer between 0 and 2147483647"),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		})
+		return
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s3query.DstObj.Bucket),
+		Prefix:    aws.String(lq.Prefix),
+		Delimiter: aws.String(lq.Delimiter),
+		MaxKeys:   int32(lq.MaxKeys),
+	}
+	if lq.Version == 2 {
+		input.StartAfter = aws.String(lq.StartAfter)
+		if lq.ContinuationToken != "" {
+			input.ContinuationToken = aws.String(lq.ContinuationToken)
+		}
+	} else {
+		input.StartAfter = aws.String(lq.Marker)
+	}
+	out, err := a.Backend.ListObjects(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+
+	encode := func(s string) string {
+		if lq.EncodingType == encodingTypeURL {
+			return s3URLEncode(s)
+		}
+		return s
+	}
+	contents := make([]types.ObjectItem, len(out.Contents))
+	for i, obj := range out.Contents {
+		contents[i] = types.ObjectItem{
+			Key:          encode(aws.ToString(obj.Key)),
+			LastModified: aws.ToTime(obj.LastModified).UTC(),
+			ETag:         aws.ToString(obj.ETag),
+			Size:         obj.Size,
+			StorageClass: string(obj.StorageClass),
+		}
+	}
+	prefixes := make([]types.CommonPrefix, len(out.CommonPrefixes))
+	for i, cp := range out.CommonPrefixes {
+		prefixes[i] = types.CommonPrefix{Prefix: encode(aws.ToString(cp.Prefix))}
+	}
+
+	if lq.Version == 2 {
+		writeXML(wr, r, &types.ListBucketV2Result{
+			Name:                  s3query.DstObj.Bucket,
+			Prefix:                encode(lq.Prefix),
+			StartAfter:            encode(lq.StartAfter),
+			ContinuationToken:     lq.ContinuationToken,
+			NextContinuationToken: aws.ToString(out.NextContinuationToken),
+			KeyCount:              out.KeyCount,
+			MaxKeys:               out.MaxKeys,
+			Delimiter:             encode(lq.Delimiter),
+			EncodingType:          lq.EncodingType,
+			IsTruncated:           out.IsTruncated,
+			Contents:              contents,
+			CommonPrefixes:        prefixes,
+		})
+		return
+	}
+	result := &types.ListBucketResult{
+		Name:           s3query.DstObj.Bucket,
+		Prefix:         encode(lq.Prefix),
+		Marker:         encode(lq.Marker),
+		MaxKeys:        out.MaxKeys,
+		Delimiter:      encode(lq.Delimiter),
+		EncodingType:   lq.EncodingType,
+		IsTruncated:    out.IsTruncated,
+		Contents:       contents,
+		CommonPrefixes: prefixes,
+	}
+	// V1 only reports NextMarker with a delimiter, without one clients resume
+	// after the last key.
+	if out.IsTruncated && lq.Delimiter != "" {
+		var next string
+		if n := len(out.Contents); n > 0 {
+			next = aws.ToString(out.Contents[n-1].Key)
+		}
+		if n := len(out.CommonPrefixes); n > 0 && aws.ToString(out.CommonPrefixes[n-1].Prefix) > next {
+			next = aws.ToString(out.CommonPrefixes[n-1].Prefix)
+		}
+		result.NextMarker = encode(next)
+	}
+	writeXML(wr, r, result)
+}
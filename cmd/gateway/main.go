@@ -1,18 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/acl"
+	"github.com/dashjay/overlay_oss/pkg/auth"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/backend/sqlite"
+	"github.com/dashjay/overlay_oss/pkg/lifecycle"
 	"github.com/dashjay/overlay_oss/pkg/parse"
 	"github.com/dashjay/overlay_oss/pkg/s3error"
 	"github.com/dashjay/overlay_oss/pkg/types"
 	"github.com/sirupsen/logrus"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -22,287 +26,497 @@ import (
 	"time"
 )
 
-type Bucket struct {
-	gorm.Model
-	BucketName string `gorm:"column=bucket_name"`
-}
-
-type Object struct {
-	gorm.Model
-	BucketName string `gorm:"column=bucket_name"`
-	KeyPrefix  string `gorm:"column=key_prefix"`
-	Data       []byte `gorm:"column=data"`
-}
-
 type S3Proxy struct {
-	DB  *gorm.DB
-	mux map[types.S3Operation]func(s3query types.S3Query, wr http.ResponseWriter, r *http.Request)
+	Backend backend.Backend
+	// Auth verifies request signatures, nil lets every request in anonymously.
+	Auth *auth.Verifier
+	mux  map[types.S3Operation]func(s3query types.S3Query, wr http.ResponseWriter, r *http.Request)
 }
 
-func NewS3Proxy() *S3Proxy {
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
-	if err != nil {
-		panic("failed to connect database")
-	}
-	logrus.Infoln("start migrating")
-	// Migrate the schema
-	db.AutoMigrate(&Bucket{})
-	db.AutoMigrate(&Object{})
-
-	logrus.Infoln("migrated")
-	s3proxy := S3Proxy{DB: db}
+func NewS3Proxy(be backend.Backend) *S3Proxy {
+	s3proxy := S3Proxy{Backend: be}
 	s3proxy.mux = map[types.S3Operation]func(s3query types.S3Query, wr http.ResponseWriter, r *http.Request){
 		types.PutBucket:    s3proxy.CreateBucket,
-		types.PutObject:    s3proxy.PutObject,
-		types.HeadObject:   s3proxy.HeadObject,
-		types.GetObject:    s3proxy.GetObject,
-		types.GetBucket:    s3proxy.GetBucket,
-		types.ListBuckets:  s3proxy.ListBuckets,
-		types.RemoveObject: s3proxy.DeleteObject,
+		types.HeadBucket:   s3proxy.HeadBucket,
+		types.DeleteBucket: s3proxy.DeleteBucket,
+
+		types.PutBucketVersioning: s3proxy.PutBucketVersioning,
+		types.GetBucketVersioning: s3proxy.GetBucketVersioning,
+		types.GetBucketVersions:   s3proxy.ListObjectVersions,
+
+		types.PutBucketTagging:    s3proxy.PutBucketTagging,
+		types.GetBucketTagging:    s3proxy.GetBucketTagging,
+		types.DeleteBucketTagging: s3proxy.DeleteBucketTagging,
+		types.PutObjectTagging:    s3proxy.PutObjectTagging,
+		types.GetObjectTagging:    s3proxy.GetObjectTagging,
+		types.DeleteObjectTagging: s3proxy.DeleteObjectTagging,
+
+		types.PutBucketLifecycle:    s3proxy.PutBucketLifecycle,
+		types.GetBucketLifecycle:    s3proxy.GetBucketLifecycle,
+		types.DeleteBucketLifecycle: s3proxy.DeleteBucketLifecycle,
+
+		types.PutBucketPolicy:    s3proxy.PutBucketPolicy,
+		types.GetBucketPolicy:    s3proxy.GetBucketPolicy,
+		types.DeleteBucketPolicy: s3proxy.DeleteBucketPolicy,
+
+		types.PutBucketAcl: s3proxy.PutBucketAcl,
+		types.GetBucketAcl: s3proxy.GetBucketAcl,
+		types.PutObjectAcl: s3proxy.PutObjectAcl,
+		types.GetObjectAcl: s3proxy.GetObjectAcl,
+
+		types.PutBucketCors:    s3proxy.PutBucketCors,
+		types.GetBucketCors:    s3proxy.GetBucketCors,
+		types.DeleteBucketCors: s3proxy.DeleteBucketCors,
+		types.PreflightObject:  s3proxy.PreflightObject,
+
+		types.PutBucketWebsite:    s3proxy.PutBucketWebsite,
+		types.GetBucketWebsite:    s3proxy.GetBucketWebsite,
+		types.DeleteBucketWebsite: s3proxy.DeleteBucketWebsite,
+
+		types.PutObject:     s3proxy.PutObject,
+		types.PostObject:    s3proxy.PostObject,
+		types.CopyObject:    s3proxy.CopyObject,
+		types.HeadObject:    s3proxy.HeadObject,
+		types.GetObject:     s3proxy.GetObject,
+		types.GetBucket:     s3proxy.GetBucket,
+		types.ListBuckets:   s3proxy.ListBuckets,
+		types.RemoveObject:  s3proxy.DeleteObject,
+		types.DeleteObjects: s3proxy.DeleteObjects,
+
+		types.InitMultipartUpload:     s3proxy.InitMultipartUpload,
+		types.MultipartUpload:         s3proxy.UploadPart,
+		types.CompleteMultipartUpload: s3proxy.CompleteMultipartUpload,
+		types.AbortMultipartUpload:    s3proxy.AbortMultipartUpload,
+		types.ListMultipartUpload:     s3proxy.ListParts,
+		types.ListBucketMultiUploads:  s3proxy.ListMultipartUploads,
 	}
 	return &s3proxy
 }
 
 func (a *S3Proxy) CreateBucket(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
 	bucket := s3query.DstObj.Bucket
-	_, err := a.createBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	grants, err := requestGrants(r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	_, err = a.Backend.CreateBucket(&backend.CreateBucketInput{
+		CreateBucketInput: s3.CreateBucketInput{
+			Bucket:           aws.String(bucket),
+			GrantFullControl: acl.FormatGrants(grants, s3types.PermissionFullControl),
+			GrantRead:        acl.FormatGrants(grants, s3types.PermissionRead),
+			GrantReadACP:     acl.FormatGrants(grants, s3types.PermissionReadAcp),
+			GrantWrite:       acl.FormatGrants(grants, s3types.PermissionWrite),
+			GrantWriteACP:    acl.FormatGrants(grants, s3types.PermissionWriteAcp),
+		},
+		Owner: resourceOwner(r),
+	})
 	if err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
 }
-func (a *S3Proxy) createBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
-	if aws.ToString(input.Bucket) == "" {
-		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidArgument}
-	}
-	var b Bucket
-	res := a.DB.First(&b, "bucket_name = ?", aws.ToString(input.Bucket))
-	if err := res.Error; err != nil {
-		if res.Error != gorm.ErrRecordNotFound {
-			return nil, err
-		}
-		a.DB.Create(&Bucket{BucketName: aws.ToString(input.Bucket)})
-		out := &s3.CreateBucketOutput{Location: aws.String("/" + aws.ToString(input.Bucket))}
-		return out, nil
+
+func (a *S3Proxy) HeadBucket(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
 	}
-	return nil, s3error.S3Error{
-		OriginError: nil,
-		Code:        s3error.ErrorCodeBucketAlreadyExists,
+}
+
+func (a *S3Proxy) DeleteBucket(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
 	}
+	wr.WriteHeader(http.StatusNoContent)
 }
 
 func (a *S3Proxy) PutObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
-	tempFile, err := ioutil.TempFile("", "temp-s3-object")
-	if err != nil {
+	// r.ContentLength is -1 for chunked transfer, the backend then reads
+	// until EOF instead of expecting an exact length.
+	if err := a.checkPutPreconditions(s3query, r); err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
-	defer os.Remove(tempFile.Name())
-	io.Copy(tempFile, r.Body)
-	tempFile.Sync()
-	tempFile.Seek(0, io.SeekStart)
-	_, err = a.putObject(&s3.PutObjectInput{
-		Body:          tempFile,
-		Bucket:        aws.String(s3query.DstObj.Bucket),
-		Key:           aws.String(s3query.DstObj.Key),
-		ContentLength: r.ContentLength,
-	})
+	meta, err := requestMeta(r)
 	if err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
-}
-func (a *S3Proxy) putObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
-	var obj Object
-	res := a.DB.First(&obj, "bucket_name = ? AND key_prefix = ?", input.Bucket, input.Key)
-	if res.Error != nil {
-		if res.Error != gorm.ErrRecordNotFound {
-			return nil, res.Error
-		}
+	input := &backend.PutObjectInput{
+		PutObjectInput: s3.PutObjectInput{
+			Body:          r.Body,
+			Bucket:        aws.String(s3query.DstObj.Bucket),
+			Key:           aws.String(s3query.DstObj.Key),
+			ContentLength: r.ContentLength,
+		},
+		Owner: resourceOwner(r),
 	}
-	obj.BucketName, obj.KeyPrefix = aws.ToString(input.Bucket), aws.ToString(input.Key)
-	obj.Data = make([]byte, input.ContentLength)
-	n, err := input.Body.Read(obj.Data)
+	meta.putObjectInput(&input.PutObjectInput)
+	output, err := a.Backend.PutObject(input)
 	if err != nil {
-		return nil, err
+		s3error.WriteError(r, wr, err)
+		return
 	}
-	if n != int(input.ContentLength) {
-		return nil, s3error.S3Error{OriginError: fmt.Errorf("content length is not equal to actual body length"), Code: s3error.ErrorCodeIncompleteBody}
+	if output.ETag != nil {
+		wr.Header().Set("ETag", aws.ToString(output.ETag))
+	}
+	if output.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(output.VersionId))
 	}
-	a.DB.Save(&obj)
-	return &s3.PutObjectOutput{}, nil
 }
 
-func (a *S3Proxy) HeadObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
-	output, err := a.getObject(&s3.GetObjectInput{
+// checkPutPreconditions honors If-None-Match: * (only create) and If-Match
+// (only overwrite a given version) on uploads.
+func (a *S3Proxy) checkPutPreconditions(s3query types.S3Query, r *http.Request) error {
+	cond := requestPreconditions(r.Header, "")
+	if cond.ifMatch == "" && cond.ifNoneMatch == "" {
+		return nil
+	}
+	current, err := a.Backend.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(s3query.DstObj.Bucket),
 		Key:    aws.String(s3query.DstObj.Key),
 	})
 	if err != nil {
-		s3error.WriteError(r, wr, err)
-		return
+		if s3error.IsNoSuchKey(err) && cond.ifMatch == "" {
+			return nil
+		}
+		return err
 	}
-	wr.Header().Set("Last-Modified", output.LastModified.String())
-	wr.Header().Set("Content-Length", strconv.Itoa(int(output.ContentLength)))
+	if cond.ifMatch != "" && !etagListMatches(cond.ifMatch, aws.ToString(current.ETag)) {
+		return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodePreconditionFailed}
+	}
+	if cond.ifNoneMatch != "" && etagListMatches(cond.ifNoneMatch, aws.ToString(current.ETag)) {
+		return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodePreconditionFailed}
+	}
+	return nil
 }
 
-func (a *S3Proxy) GetObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
-	output, err := a.getObject(&s3.GetObjectInput{Bucket: aws.String(s3query.DstObj.Bucket), Key: aws.String(s3query.DstObj.Key)})
+// writePrecondition answers a failed GET/HEAD precondition, reporting whether
+// it did so.
+func writePrecondition(wr http.ResponseWriter, r *http.Request, output *s3.HeadObjectOutput) bool {
+	switch requestPreconditions(r.Header, "").evaluate(aws.ToString(output.ETag), aws.ToTime(output.LastModified)) {
+	case http.StatusPreconditionFailed:
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodePreconditionFailed})
+		return true
+	case http.StatusNotModified:
+		if output.ETag != nil {
+			wr.Header().Set("ETag", aws.ToString(output.ETag))
+		}
+		if output.LastModified != nil {
+			wr.Header().Set("Last-Modified", output.LastModified.UTC().Format(http.TimeFormat))
+		}
+		wr.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func (a *S3Proxy) HeadObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	input := &s3.HeadObjectInput{
+		Bucket:     aws.String(s3query.DstObj.Bucket),
+		Key:        aws.String(s3query.DstObj.Key),
+		PartNumber: int32(s3query.MpQuery.PartNumber),
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	output, err := a.Backend.HeadObject(input)
 	if err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
-	n, _ := io.Copy(wr, output.Body)
-	wr.Header().Set("Content-Length", strconv.Itoa(int(n)))
-	return
-}
-func (a *S3Proxy) getObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
-	var obj Object
-	res := a.DB.First(&obj, "bucket_name = ? AND key_prefix = ?", aws.ToString(input.Bucket), aws.ToString(input.Key))
-	if err := res.Error; err != nil {
-		if err != gorm.ErrRecordNotFound {
-			return nil, err
-		}
-		return nil, s3error.S3Error{Code: s3error.ErrorCodeNoSuchKey}
+	if writePrecondition(wr, r, output) {
+		return
 	}
-	return &s3.GetObjectOutput{
-		Body:          io.NopCloser(bytes.NewBuffer(obj.Data)),
-		ContentLength: int64(len(obj.Data)),
-		LastModified:  &obj.UpdatedAt,
-	}, nil
+	setObjectHeaders(wr.Header(), output)
 }
 
-func (a *S3Proxy) GetBucket(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
-	out, err := a.getBucket(&s3.ListObjectsV2Input{
-		Bucket:    aws.String(s3query.DstObj.Bucket),
-		Prefix:    aws.String(s3query.ListQuery.Prefix),
-		Delimiter: aws.String(s3query.ListQuery.Delimiter),
-	})
+func (a *S3Proxy) GetObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	input := &s3.GetObjectInput{
+		Bucket:     aws.String(s3query.DstObj.Bucket),
+		Key:        aws.String(s3query.DstObj.Key),
+		PartNumber: int32(s3query.MpQuery.PartNumber),
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	if v := r.Header.Get("Range"); v != "" {
+		input.Range = aws.String(v)
+	}
+	output, err := a.Backend.GetObject(input)
 	if err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
-	bin, err := xml.Marshal(out)
-	if err != nil {
-		s3error.WriteError(r, wr, err)
+	defer output.Body.Close()
+	head := &s3.HeadObjectOutput{
+		ContentLength:      output.ContentLength,
+		LastModified:       output.LastModified,
+		ETag:               output.ETag,
+		PartsCount:         output.PartsCount,
+		ContentType:        output.ContentType,
+		ContentEncoding:    output.ContentEncoding,
+		ContentDisposition: output.ContentDisposition,
+		ContentLanguage:    output.ContentLanguage,
+		CacheControl:       output.CacheControl,
+		Expires:            output.Expires,
+		Metadata:           output.Metadata,
+		VersionId:          output.VersionId,
+	}
+	if writePrecondition(wr, r, head) {
 		return
 	}
-	wr.Write(wrapXMLHeader(bin))
+	setObjectHeaders(wr.Header(), head)
+	setTagCount(wr.Header(), output.TagCount)
+	if output.ContentRange != nil {
+		wr.Header().Set("Content-Range", aws.ToString(output.ContentRange))
+		wr.WriteHeader(http.StatusPartialContent)
+	}
+	if _, err := io.Copy(wr, output.Body); err != nil {
+		logrus.WithError(err).Warnln("write object body failed")
+	}
 }
-func (a *S3Proxy) getBucket(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
-	var objects []Object
-	a.DB.Find(&objects, "bucket_name = ? AND key_prefix like ?", aws.ToString(input.Bucket), aws.ToString(input.Prefix)+"%")
-	stored := make(map[string]struct{}, 0)
-	out := &s3.ListObjectsV2Output{Contents: make([]s3types.Object, 0)}
-	for i := range objects {
-		suffix := strings.TrimPrefix(objects[i].KeyPrefix, aws.ToString(input.Prefix))
-		// is a file
-		if !strings.Contains(suffix, "/") {
-			out.Contents = append(out.Contents, s3types.Object{
-				Key:          aws.String(objects[i].KeyPrefix),
-				LastModified: &objects[i].UpdatedAt,
-				Size:         int64(len(objects[i].Data)),
-				StorageClass: "STANDARD",
-			})
-			out.KeyCount += 1
-		} else if suffix == "" {
-		} else {
-			// is a dir
-			subPrefix := suffix[:strings.Index(suffix, "/")]
-			if _, exists := stored[subPrefix]; !exists {
-				out.Contents = append(out.Contents, s3types.Object{Key: aws.String(subPrefix), LastModified: aws.Time(time.Unix(0, 0))})
-				stored[subPrefix] = struct{}{}
-				out.KeyCount += 1
-			}
-		}
+
+// setObjectHeaders writes the response headers shared by GET and HEAD.
+func setObjectHeaders(h http.Header, output *s3.HeadObjectOutput) {
+	h.Set("Content-Length", strconv.FormatInt(output.ContentLength, 10))
+	h.Set("Accept-Ranges", "bytes")
+	if output.LastModified != nil {
+		h.Set("Last-Modified", output.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if output.ETag != nil {
+		h.Set("ETag", aws.ToString(output.ETag))
+	}
+	if output.PartsCount != 0 {
+		h.Set("x-amz-mp-parts-count", strconv.Itoa(int(output.PartsCount)))
+	}
+	if output.VersionId != nil {
+		h.Set("x-amz-version-id", aws.ToString(output.VersionId))
 	}
-	out.Name = input.Bucket
-	return out, nil
+	setMetaHeaders(h, output)
 }
 
 func (a *S3Proxy) ListBuckets(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
-	buckets, err := a.listBuckets(&s3.ListBucketsInput{})
+	buckets, err := a.Backend.ListBuckets(&s3.ListBucketsInput{})
 	if err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
-	bin, err := xml.Marshal(buckets)
-	if err != nil {
-		s3error.WriteError(r, wr, err)
-		return
+	result := &types.ListAllMyBucketsResult{
+		Owner:   requestOwner(r),
+		Buckets: make([]types.BucketItem, 0, len(buckets.Buckets)),
 	}
-	logrus.Infof("out: %s\n", bin)
-	wr.Write(wrapXMLHeader(bin))
-}
-func (a *S3Proxy) listBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
-	var buckets []Bucket
-	a.DB.Find(&buckets)
-	var outBuckets = make([]s3types.Bucket, 0)
-	outBuckets = append(outBuckets, s3types.Bucket{
-		CreationDate: aws.Time(time.Now()),
-		Name:         aws.String("today"),
-	})
-
-	for i := range buckets {
-		outBuckets = append(outBuckets, s3types.Bucket{
-			CreationDate: aws.Time(buckets[i].CreatedAt),
-			Name:         aws.String(buckets[i].BucketName),
+	for _, bucket := range buckets.Buckets {
+		// With authentication the requester sees only the buckets they own.
+		if a.Auth != nil {
+			out, err := a.Backend.GetBucketAcl(&s3.GetBucketAclInput{Bucket: bucket.Name})
+			if err != nil {
+				if s3error.CodeOf(err) == s3error.ErrorCodeNoSuchBucket {
+					continue
+				}
+				s3error.WriteError(r, wr, err)
+				return
+			}
+			if !ownedBy(out.Owner, requestOwner(r).ID) {
+				continue
+			}
+		}
+		result.Buckets = append(result.Buckets, types.BucketItem{
+			Name:         aws.ToString(bucket.Name),
+			CreationDate: aws.ToTime(bucket.CreationDate).UTC(),
 		})
 	}
-	return &s3.ListBucketsOutput{
-		Buckets: outBuckets,
-		Owner: &s3types.Owner{
-			DisplayName: aws.String("dashjay"),
-			ID:          aws.String("dashjay"),
-		},
-	}, nil
+	writeXML(wr, r, result)
 }
 
 func (a *S3Proxy) DeleteObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
-	_, err := a.deleteObject(&s3.DeleteObjectInput{
+	input := &s3.DeleteObjectInput{
 		Bucket: aws.String(s3query.DstObj.Bucket),
 		Key:    aws.String(s3query.DstObj.Key),
-	})
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	out, err := a.Backend.DeleteObject(input)
 	if err != nil {
 		s3error.WriteError(r, wr, err)
 		return
 	}
-}
-func (a *S3Proxy) deleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
-	var out Object
-	res := a.DB.First(&out, "bucket_name = ? AND key_prefix = ?", aws.ToString(input.Bucket), aws.ToString(input.Key))
-	if err := res.Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeNoSuchKey}
-		}
-		return nil, err
+	if out.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(out.VersionId))
 	}
-	a.DB.Delete(&out)
-	return &s3.DeleteObjectOutput{}, nil
+	if out.DeleteMarker {
+		wr.Header().Set("x-amz-delete-marker", "true")
+	}
+	wr.WriteHeader(http.StatusNoContent)
 }
 
 func (a *S3Proxy) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	if a.Auth != nil {
+		cred, err := a.Auth.Verify(r)
+		if err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+		if cred != nil {
+			r = r.WithContext(auth.WithCredential(r.Context(), cred))
+		}
+	} else if err := auth.DecodeChunked(r); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
 	query := parse.S3Query(r)
 	logrus.Infof("query: %#v\n", query)
+	if query.Type != types.PreflightObject {
+		a.applyCORS(query, wr, r)
+	}
+	if err := a.authorize(query, r); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
 	a.ServeMux(query.Type)(query, wr, r)
 }
 
+// requestOwner is the owner reported to the requester, anonymous when
+// authentication is disabled.
+func requestOwner(r *http.Request) types.Owner {
+	if cred := auth.FromContext(r.Context()); cred != nil {
+		return types.Owner{ID: cred.ID(), DisplayName: cred.DisplayName}
+	}
+	return types.Owner{ID: "anonymous", DisplayName: "anonymous"}
+}
+
+// resourceOwner is the owner recorded for what the request creates, nil for
+// anonymous requests.
+func resourceOwner(r *http.Request) *s3types.Owner {
+	cred := auth.FromContext(r.Context())
+	if cred == nil {
+		return nil
+	}
+	return &s3types.Owner{ID: aws.String(cred.ID()), DisplayName: aws.String(cred.DisplayName)}
+}
+
 var _ http.Handler = (*S3Proxy)(nil)
 
 func (a *S3Proxy) ServeMux(s3Op types.S3Operation) func(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
 	if handler, ok := a.mux[s3Op]; ok {
 		return handler
 	}
+	if s3Op == types.ErrorOperation {
+		return func(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+			s3error.WriteError(r, wr, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidArgument})
+		}
+	}
 	return func(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
 		s3error.WriteError(r, wr, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNotImplemented})
 	}
 }
 
 func main() {
-	http.ListenAndServe(":8000", NewS3Proxy())
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		if err := presignCommand(os.Args[2:]); err != nil {
+			logrus.WithError(err).Fatalln("presign failed")
+		}
+		return
+	}
+	addr := flag.String("addr", ":8000", "address the S3 API listens on")
+	dbPath := flag.String("db", "test.db", "path of the SQLite metadata database")
+	dataDir := flag.String("data", "data", "directory object payloads are stored in")
+	credentials := flag.String("credentials", "", "JSON file of access keys, empty disables authentication")
+	region := flag.String("region", "", "only accept signatures scoped to this region, empty accepts any")
+	lifecycleInterval := flag.Duration("lifecycle-interval", time.Hour, "how often bucket lifecycle rules are applied, 0 disables expiration")
+	adminAddr := flag.String("admin-addr", "", "address of the unauthenticated admin API minting presigned URLs, empty disables it")
+	presignKey := flag.String("presign-key", "", "access key presigned URLs are signed with, may be empty with a single credential")
+	endpoint := flag.String("endpoint", "", "URL clients reach the S3 API at, derived from -addr when empty")
+	domains := flag.String("domains", "", "comma separated base domains of virtual-hosted-style requests, empty serves path-style only")
+	websiteAddr := flag.String("website-addr", "", "address buckets configured as websites are served at, empty disables it")
+	websiteDomains := flag.String("website-domains", "", "comma separated base domains of website requests, other hosts name their bucket in full")
+	flag.Parse()
+
+	parse.Domains = splitList(*domains)
+
+	be, err := sqlite.New(*dbPath, *dataDir)
+	if err != nil {
+		logrus.WithError(err).Fatalln("open database failed")
+	}
+	proxy := NewS3Proxy(be)
+	if *lifecycleInterval > 0 {
+		go lifecycle.NewWorker(be, *lifecycleInterval).Run(context.Background())
+	}
+	if *credentials != "" {
+		store, err := auth.LoadCredentials(*credentials)
+		if err != nil {
+			logrus.WithError(err).Fatalln("load credentials failed")
+		}
+		proxy.Auth = auth.NewVerifier(store)
+		proxy.Auth.Region = *region
+		if *adminAddr != "" {
+			if *endpoint == "" {
+				*endpoint = defaultEndpoint(*addr)
+			}
+			p, err := newPresigner(store, *presignKey, *endpoint, *region)
+			if err != nil {
+				logrus.WithError(err).Fatalln("configure presigning failed")
+			}
+			go func() {
+				logrus.Fatalln(http.ListenAndServe(*adminAddr, p))
+			}()
+		}
+	} else if *adminAddr != "" {
+		logrus.Fatalln("the admin API needs -credentials to sign with")
+	}
+	if *websiteAddr != "" {
+		go func() {
+			logrus.Fatalln(http.ListenAndServe(*websiteAddr, &website{proxy: proxy, domains: splitList(*websiteDomains)}))
+		}()
+	}
+	logrus.Fatalln(http.ListenAndServe(*addr, proxy))
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 var wrapXMLHeader = func(body []byte) []byte {
 	body = append([]byte(xml.Header), body...)
 	return body
 }
+
+// decodeXML reads the whole body of r, at most max bytes, and unmarshals it
+// into v. Reading to EOF lets a signed payload hash be checked before the
+// document is used. Oversized or malformed documents fail with code.
+func decodeXML(r *http.Request, max int64, v interface{}, code s3error.ErrorCode) error {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > max {
+		return s3error.S3Error{OriginError: fmt.Errorf("request body exceeds %d bytes", max), Code: code}
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		return s3error.S3Error{OriginError: err, Code: code}
+	}
+	return nil
+}
+
+// writeXML marshals v as the XML response body.
+func writeXML(wr http.ResponseWriter, r *http.Request, v interface{}) {
+	bin, err := xml.Marshal(v)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/xml")
+	wr.Write(wrapXMLHeader(bin))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/acl"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+const (
+	userMetaPrefix = "x-amz-meta-"
+	// maxUserMetaSize bounds the summed length of x-amz-meta-* keys and values.
+	maxUserMetaSize = 2 << 10
+)
+
+// objectMeta is the system and user metadata an upload stores with the object.
+type objectMeta struct {
+	contentType        *string
+	contentEncoding    *string
+	contentDisposition *string
+	contentLanguage    *string
+	cacheControl       *string
+	expires            *time.Time
+	metadata           map[string]string
+	// tagging is the validated x-amz-tagging header in its URL query form.
+	tagging *string
+	// The grants of x-amz-acl or x-amz-grant-* in x-amz-grant-* form,
+	// objects have no WRITE permission.
+	grantFullControl *string
+	grantRead        *string
+	grantReadACP     *string
+	grantWriteACP    *string
+}
+
+func headerValue(h http.Header, name string) *string {
+	if v := h.Get(name); v != "" {
+		return aws.String(v)
+	}
+	return nil
+}
+
+// requestMeta collects the metadata headers of an upload. User metadata keys
+// are lower-cased and stored without their x-amz-meta- prefix.
+func requestMeta(r *http.Request) (*objectMeta, error) {
+	h := r.Header
+	meta := &objectMeta{
+		contentType:        headerValue(h, "Content-Type"),
+		contentEncoding:    headerValue(h, "Content-Encoding"),
+		contentDisposition: headerValue(h, "Content-Disposition"),
+		contentLanguage:    headerValue(h, "Content-Language"),
+		cacheControl:       headerValue(h, "Cache-Control"),
+		metadata:           map[string]string{},
+	}
+	if v := h.Get("Expires"); v != "" {
+		// S3 keeps unparsable Expires values out of the object silently.
+		if t, err := http.ParseTime(v); err == nil {
+			meta.expires = &t
+		}
+	}
+	var err error
+	size := 0
+	for name, values := range h {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, userMetaPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, userMetaPrefix)
+		value := strings.Join(values, ",")
+		meta.metadata[key] = value
+		size += len(key) + len(value)
+	}
+	if size > maxUserMetaSize {
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("user metadata is %d bytes, the limit is %d", size, maxUserMetaSize),
+			Code:        s3error.ErrorCodeMetadataTooLarge,
+		}
+	}
+	if meta.tagging, err = taggingHeader(h); err != nil {
+		return nil, err
+	}
+	grants, err := requestGrants(r)
+	if err != nil {
+		return nil, err
+	}
+	meta.grantFullControl = acl.FormatGrants(grants, s3types.PermissionFullControl)
+	meta.grantRead = acl.FormatGrants(grants, s3types.PermissionRead)
+	meta.grantReadACP = acl.FormatGrants(grants, s3types.PermissionReadAcp)
+	meta.grantWriteACP = acl.FormatGrants(grants, s3types.PermissionWriteAcp)
+	return meta, nil
+}
+
+func (m *objectMeta) putObjectInput(input *s3.PutObjectInput) {
+	input.ContentType = m.contentType
+	input.ContentEncoding = m.contentEncoding
+	input.ContentDisposition = m.contentDisposition
+	input.ContentLanguage = m.contentLanguage
+	input.CacheControl = m.cacheControl
+	input.Expires = m.expires
+	input.Metadata = m.metadata
+	input.Tagging = m.tagging
+	input.GrantFullControl = m.grantFullControl
+	input.GrantRead = m.grantRead
+	input.GrantReadACP = m.grantReadACP
+	input.GrantWriteACP = m.grantWriteACP
+}
+
+func (m *objectMeta) createMultipartUploadInput(input *s3.CreateMultipartUploadInput) {
+	input.ContentType = m.contentType
+	input.ContentEncoding = m.contentEncoding
+	input.ContentDisposition = m.contentDisposition
+	input.ContentLanguage = m.contentLanguage
+	input.CacheControl = m.cacheControl
+	input.Expires = m.expires
+	input.Metadata = m.metadata
+	input.Tagging = m.tagging
+	input.GrantFullControl = m.grantFullControl
+	input.GrantRead = m.grantRead
+	input.GrantReadACP = m.grantReadACP
+	input.GrantWriteACP = m.grantWriteACP
+}
+
+// copyGrants sets the ACL of a copy, which unlike its metadata is never
+// taken from the source.
+func (m *objectMeta) copyGrants(input *s3.CopyObjectInput) {
+	input.GrantFullControl = m.grantFullControl
+	input.GrantRead = m.grantRead
+	input.GrantReadACP = m.grantReadACP
+	input.GrantWriteACP = m.grantWriteACP
+}
+
+func (m *objectMeta) copyObjectInput(input *s3.CopyObjectInput) {
+	input.ContentType = m.contentType
+	input.ContentEncoding = m.contentEncoding
+	input.ContentDisposition = m.contentDisposition
+	input.ContentLanguage = m.contentLanguage
+	input.CacheControl = m.cacheControl
+	input.Expires = m.expires
+	input.Metadata = m.metadata
+}
+
+// setMetaHeaders echoes the stored metadata of an object on GET and HEAD.
+func setMetaHeaders(h http.Header, output *s3.HeadObjectOutput) {
+	// Without a stored type Go would sniff one from the body, S3 reports its
+	// default instead.
+	h.Set("Content-Type", "binary/octet-stream")
+	if output.ContentType != nil {
+		h.Set("Content-Type", aws.ToString(output.ContentType))
+	}
+	if output.ContentEncoding != nil {
+		h.Set("Content-Encoding", aws.ToString(output.ContentEncoding))
+	}
+	if output.ContentDisposition != nil {
+		h.Set("Content-Disposition", aws.ToString(output.ContentDisposition))
+	}
+	if output.ContentLanguage != nil {
+		h.Set("Content-Language", aws.ToString(output.ContentLanguage))
+	}
+	if output.CacheControl != nil {
+		h.Set("Cache-Control", aws.ToString(output.CacheControl))
+	}
+	if output.Expires != nil {
+		h.Set("Expires", output.Expires.UTC().Format(http.TimeFormat))
+	}
+	for key, value := range output.Metadata {
+		h.Set(userMetaPrefix+key, value)
+	}
+}
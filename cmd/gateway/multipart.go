@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+// maxCompleteBody bounds the CompleteMultipartUpload document, which lists
+// up to 10000 parts.
+const maxCompleteBody = 2 << 20
+
+func (a *S3Proxy) InitMultipartUpload(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	meta, err := requestMeta(r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	input := &backend.CreateMultipartUploadInput{
+		CreateMultipartUploadInput: s3.CreateMultipartUploadInput{
+			Bucket: aws.String(s3query.DstObj.Bucket),
+			Key:    aws.String(s3query.DstObj.Key),
+		},
+		Owner: resourceOwner(r),
+	}
+	meta.createMultipartUploadInput(&input.CreateMultipartUploadInput)
+	out, err := a.Backend.CreateMultipartUpload(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	writeXML(wr, r, &types.InitiateMultipartUploadResult{
+		Bucket:   aws.ToString(out.Bucket),
+		Key:      aws.ToString(out.Key),
+		UploadId: aws.ToString(out.UploadId),
+	})
+}
+
+func (a *S3Proxy) UploadPart(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	if s3query.HasCopy() {
+		a.UploadPartCopy(s3query, wr, r)
+		return
+	}
+	out, err := a.Backend.UploadPart(&s3.UploadPartInput{
+		Body:          r.Body,
+		Bucket:        aws.String(s3query.DstObj.Bucket),
+		Key:           aws.String(s3query.DstObj.Key),
+		UploadId:      aws.String(s3query.MpQuery.UploadId),
+		PartNumber:    int32(s3query.MpQuery.PartNumber),
+		ContentLength: r.ContentLength,
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.Header().Set("ETag", aws.ToString(out.ETag))
+}
+
+func (a *S3Proxy) CompleteMultipartUpload(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	var body types.CompleteMultipartUploadRequest
+	if err := decodeXML(r, maxCompleteBody, &body, s3error.ErrorCodeMalformedXML); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	parts := make([]s3types.CompletedPart, len(body.Parts))
+	for i := range body.Parts {
+		parts[i] = s3types.CompletedPart{
+			ETag:       aws.String(body.Parts[i].ETag),
+			PartNumber: body.Parts[i].PartNumber,
+		}
+	}
+	out, err := a.Backend.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s3query.DstObj.Bucket),
+		Key:             aws.String(s3query.DstObj.Key),
+		UploadId:        aws.String(s3query.MpQuery.UploadId),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if out.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(out.VersionId))
+	}
+	writeXML(wr, r, &types.CompleteMultipartUploadResult{
+		Location: aws.ToString(out.Location),
+		Bucket:   aws.ToString(out.Bucket),
+		Key:      aws.ToString(out.Key),
+		ETag:     aws.ToString(out.ETag),
+	})
+}
+
+func (a *S3Proxy) AbortMultipartUpload(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s3query.DstObj.Bucket),
+		Key:      aws.String(s3query.DstObj.Key),
+		UploadId: aws.String(s3query.MpQuery.UploadId),
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (a *S3Proxy) ListParts(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.ListParts(&s3.ListPartsInput{
+		Bucket:           aws.String(s3query.DstObj.Bucket),
+		Key:              aws.String(s3query.DstObj.Key),
+		UploadId:         aws.String(s3query.MpQuery.UploadId),
+		MaxParts:         int32(s3query.MpQuery.MaxParts),
+		PartNumberMarker: aws.String(strconv.FormatInt(s3query.MpQuery.Marker, 10)),
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	result := &types.ListPartsResult{
+		Bucket:               aws.ToString(out.Bucket),
+		Key:                  aws.ToString(out.Key),
+		UploadId:             aws.ToString(out.UploadId),
+		StorageClass:         string(out.StorageClass),
+		PartNumberMarker:     aws.ToString(out.PartNumberMarker),
+		NextPartNumberMarker: aws.ToString(out.NextPartNumberMarker),
+		MaxParts:             out.MaxParts,
+		IsTruncated:          out.IsTruncated,
+		Parts:                make([]types.PartItem, len(out.Parts)),
+	}
+	for i, part := range out.Parts {
+		result.Parts[i] = types.PartItem{
+			PartNumber:   part.PartNumber,
+			LastModified: aws.ToTime(part.LastModified).UTC(),
+			ETag:         aws.ToString(part.ETag),
+			Size:         part.Size,
+		}
+	}
+	writeXML(wr, r, result)
+}
+
+func (a *S3Proxy) ListMultipartUploads(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+		Bucket:         aws.String(s3query.DstObj.Bucket),
+		Prefix:         aws.String(s3query.ListQuery.Prefix),
+		Delimiter:      aws.String(s3query.ListQuery.Delimiter),
+		KeyMarker:      aws.String(s3query.MpQuery.KeyMarker),
+		UploadIdMarker: aws.String(s3query.MpQuery.UploadIdMarker),
+		MaxUploads:     int32(s3query.MpQuery.MaxUploads),
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	result := &types.ListMultipartUploadsResult{
+		Bucket:             aws.ToString(out.Bucket),
+		KeyMarker:          aws.ToString(out.KeyMarker),
+		UploadIdMarker:     aws.ToString(out.UploadIdMarker),
+		NextKeyMarker:      aws.ToString(out.NextKeyMarker),
+		NextUploadIdMarker: aws.ToString(out.NextUploadIdMarker),
+		Prefix:             aws.ToString(out.Prefix),
+		Delimiter:          aws.ToString(out.Delimiter),
+		MaxUploads:         out.MaxUploads,
+		IsTruncated:        out.IsTruncated,
+		Uploads:            make([]types.UploadItem, len(out.Uploads)),
+	}
+	for i, upload := range out.Uploads {
+		result.Uploads[i] = types.UploadItem{
+			Key:          aws.ToString(upload.Key),
+			UploadId:     aws.ToString(upload.UploadId),
+			StorageClass: string(upload.StorageClass),
+			Initiated:    aws.ToTime(upload.Initiated).UTC(),
+		}
+	}
+	for _, cp := range out.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, types.CommonPrefix{Prefix: aws.ToString(cp.Prefix)})
+	}
+	writeXML(wr, r, result)
+}
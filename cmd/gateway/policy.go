@@ -0,0 +1,257 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/auth"
+	"github.com/dashjay/overlay_oss/pkg/policy"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+// policyActions names the IAM action bucket policies grant for each
+// operation. Operations missing here are not subject to bucket policies,
+// among them the policy calls, which are left to the bucket owner so a Deny
+// cannot lock the owner out.
+var policyActions = map[types.S3Operation]string{
+	types.PutBucket:              "s3:CreateBucket",
+	types.DeleteBucket:           "s3:DeleteBucket",
+	types.HeadBucket:             "s3:ListBucket",
+	types.GetBucket:              "s3:ListBucket",
+	types.GetBucketVersions:      "s3:ListBucketVersions",
+	types.ListBucketMultiUploads: "s3:ListBucketMultipartUploads",
+	types.PutBucketVersioning:    "s3:PutBucketVersioning",
+	types.GetBucketVersioning:    "s3:GetBucketVersioning",
+	types.PutBucketTagging:       "s3:PutBucketTagging",
+	types.GetBucketTagging:       "s3:GetBucketTagging",
+	types.DeleteBucketTagging:    "s3:PutBucketTagging",
+	types.PutBucketLifecycle:     "s3:PutLifecycleConfiguration",
+	types.GetBucketLifecycle:     "s3:GetLifecycleConfiguration",
+	types.DeleteBucketLifecycle:  "s3:PutLifecycleConfiguration",
+	types.PutBucketAcl:           "s3:PutBucketAcl",
+	types.GetBucketAcl:           "s3:GetBucketAcl",
+	types.PutBucketCors:          "s3:PutBucketCORS",
+	types.GetBucketCors:          "s3:GetBucketCORS",
+	types.DeleteBucketCors:       "s3:PutBucketCORS",
+	types.PutBucketWebsite:       "s3:PutBucketWebsite",
+	types.GetBucketWebsite:       "s3:GetBucketWebsite",
+	types.DeleteBucketWebsite:    "s3:DeleteBucketWebsite",
+
+	types.GetObject:               "s3:GetObject",
+	types.HeadObject:              "s3:GetObject",
+	types.PutObject:               "s3:PutObject",
+	types.CopyObject:              "s3:PutObject",
+	types.InitMultipartUpload:     "s3:PutObject",
+	types.MultipartUpload:         "s3:PutObject",
+	types.CompleteMultipartUpload: "s3:PutObject",
+	types.AbortMultipartUpload:    "s3:AbortMultipartUpload",
+	types.ListMultipartUpload:     "s3:ListMultipartUploadParts",
+	types.RemoveObject:            "s3:DeleteObject",
+	types.PutObjectTagging:        "s3:PutObjectTagging",
+	types.GetObjectTagging:        "s3:GetObjectTagging",
+	types.DeleteObjectTagging:     "s3:DeleteObjectTagging",
+	types.PutObjectAcl:            "s3:PutObjectAcl",
+	types.GetObjectAcl:            "s3:GetObjectAcl",
+}
+
+// versionActions replaces an action when the request names a version.
+var versionActions = map[string]string{
+	"s3:GetObject":           "s3:GetObjectVersion",
+	"s3:DeleteObject":        "s3:DeleteObjectVersion",
+	"s3:PutObjectTagging":    "s3:PutObjectVersionTagging",
+	"s3:GetObjectTagging":    "s3:GetObjectVersionTagging",
+	"s3:DeleteObjectTagging": "s3:DeleteObjectVersionTagging",
+	"s3:PutObjectAcl":        "s3:PutObjectVersionAcl",
+	"s3:GetObjectAcl":        "s3:GetObjectVersionAcl",
+}
+
+func objectAction(action string, obj types.S3Object) string {
+	if v, ok := versionActions[action]; ok && obj.VersionId != "" {
+		return v
+	}
+	return action
+}
+
+// policyConditions collects the condition keys bucket policies can test.
+func policyConditions(r *http.Request) map[string][]string {
+	conditions := map[string][]string{
+		"aws:securetransport": {"false"},
+	}
+	if r.TLS != nil {
+		conditions["aws:securetransport"] = []string{"true"}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		conditions["aws:sourceip"] = []string{host}
+	}
+	if v := r.UserAgent(); v != "" {
+		conditions["aws:useragent"] = []string{v}
+	}
+	if v := r.Referer(); v != "" {
+		conditions["aws:referer"] = []string{v}
+	}
+	if cred := auth.FromContext(r.Context()); cred != nil {
+		conditions["aws:userid"] = []string{cred.ID()}
+		conditions["aws:username"] = []string{cred.DisplayName}
+	}
+	query := r.URL.Query()
+	for _, key := range []string{"prefix", "delimiter", "max-keys", "versionId"} {
+		if values, ok := query[key]; ok {
+			conditions["s3:"+strings.ToLower(key)] = values
+		}
+	}
+	return conditions
+}
+
+// evaluatePolicy applies the policy of bucket, if any, to action on key.
+func (a *S3Proxy) evaluatePolicy(r *http.Request, bucket, key, action string) (policy.Decision, error) {
+	if bucket == "" {
+		return policy.NoMatch, nil
+	}
+	out, err := a.Backend.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		switch s3error.CodeOf(err) {
+		case s3error.ErrorCodeNoSuchBucketPolicy, s3error.ErrorCodeNoSuchBucket:
+			return policy.NoMatch, nil
+		}
+		return policy.NoMatch, err
+	}
+	p, err := policy.Parse([]byte(aws.ToString(out.Policy)), bucket)
+	if err != nil {
+		return policy.NoMatch, err
+	}
+	var principal string
+	if cred := auth.FromContext(r.Context()); cred != nil {
+		principal = cred.ID()
+	}
+	return p.Evaluate(&policy.Request{
+		Principal:  principal,
+		Action:     action,
+		Resource:   policy.ResourceARN(bucket, key),
+		Conditions: policyConditions(r),
+	}), nil
+}
+
+// checkAccess admits action on obj when the bucket policy allows it, or
+// when no statement matches and the requester is an owner or granted the
+// action, see aclAllows. A Deny always wins. Without authentication every
+// action a policy does not deny is admitted.
+func (a *S3Proxy) checkAccess(r *http.Request, obj types.S3Object, action string) error {
+	decision, err := a.evaluatePolicy(r, obj.Bucket, obj.Key, action)
+	if err != nil {
+		return err
+	}
+	denied := s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeAccessDenied}
+	if decision == policy.Deny {
+		return denied
+	}
+	if decision == policy.NoMatch && a.Auth != nil {
+		allowed, err := a.aclAllows(r, obj, action)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return denied
+		}
+	}
+	return nil
+}
+
+// checkOwner admits only the owner of bucket when authentication is
+// enabled. Authenticated requesters are let through to the NoSuchBucket of a
+// missing bucket.
+func (a *S3Proxy) checkOwner(r *http.Request, bucket string) error {
+	if a.Auth == nil {
+		return nil
+	}
+	denied := s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeAccessDenied}
+	cred := auth.FromContext(r.Context())
+	if cred == nil {
+		return denied
+	}
+	out, err := a.Backend.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if s3error.CodeOf(err) == s3error.ErrorCodeNoSuchBucket {
+			return nil
+		}
+		return err
+	}
+	if !ownedBy(out.Owner, cred.ID()) {
+		return denied
+	}
+	return nil
+}
+
+// authorize is consulted by ServeHTTP before dispatching s3query. A copy
+// also needs read access to its source. DeleteObjects checks its keys one
+// by one and PostObject its key once the form is read. Preflights are
+// answered from the CORS rules alone.
+func (a *S3Proxy) authorize(s3query types.S3Query, r *http.Request) error {
+	switch s3query.Type {
+	case types.DeleteObjects, types.PostObject, types.PreflightObject:
+		return nil
+	case types.PutBucketPolicy, types.GetBucketPolicy, types.DeleteBucketPolicy:
+		return a.checkOwner(r, s3query.DstObj.Bucket)
+	}
+	action, ok := policyActions[s3query.Type]
+	if !ok {
+		if a.Auth != nil && auth.FromContext(r.Context()) == nil {
+			return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeAccessDenied}
+		}
+		return nil
+	}
+	dst := s3query.DstObj
+	if err := a.checkAccess(r, dst, objectAction(action, dst)); err != nil {
+		return err
+	}
+	if s3query.HasCopy() {
+		src := s3query.SrcObj
+		return a.checkAccess(r, src, objectAction("s3:GetObject", src))
+	}
+	return nil
+}
+
+func (a *S3Proxy) PutBucketPolicy(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, policy.MaxSize+1))
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if _, err := policy.Parse(body, s3query.DstObj.Bucket); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	_, err = a.Backend.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(s3query.DstObj.Bucket),
+		Policy: aws.String(string(body)),
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (a *S3Proxy) GetBucketPolicy(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(wr, aws.ToString(out.Policy))
+}
+
+func (a *S3Proxy) DeleteBucketPolicy(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
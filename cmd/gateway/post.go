@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/auth"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/postpolicy"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+// maxPostPreDataLength bounds the form fields preceding the file.
+const maxPostPreDataLength = 20 << 10
+
+// postHeaders maps the form fields that stand in for request headers of a
+// PUT, x-amz-meta-* and x-amz-grant-* fields keep their names.
+var postHeaders = map[string]string{
+	"acl":                 "x-amz-acl",
+	"cache-control":       "Cache-Control",
+	"content-type":        "Content-Type",
+	"content-disposition": "Content-Disposition",
+	"content-encoding":    "Content-Encoding",
+	"content-language":    "Content-Language",
+	"expires":             "Expires",
+	"x-amz-tagging":       "x-amz-tagging",
+}
+
+// readPostForm reads the fields up to the file, whose part is returned
+// unread. Field names are lower-cased, fields after the file are ignored.
+func readPostForm(r *http.Request) (map[string]string, *multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		if err == http.ErrNotMultipart {
+			return nil, nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeRequestIsNotMultiPartContent}
+		}
+		return nil, nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedPOSTRequest}
+	}
+	fields := map[string]string{}
+	size := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeIncorrectNumberOfFilesInPostRequest}
+		}
+		if err != nil {
+			return nil, nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedPOSTRequest}
+		}
+		name := strings.ToLower(part.FormName())
+		if name == "" {
+			continue
+		}
+		if name == "file" {
+			return fields, part, nil
+		}
+		value, err := ioutil.ReadAll(io.LimitReader(part, int64(maxPostPreDataLength-size+1)))
+		if err != nil {
+			return nil, nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedPOSTRequest}
+		}
+		if size += len(name) + len(value); size > maxPostPreDataLength {
+			return nil, nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeMaxPostPreDataLengthExceededError}
+		}
+		fields[name] = string(value)
+	}
+}
+
+// lengthRangeReader enforces the content-length-range of a POST policy while
+// the file streams into the backend, which discards it on error.
+type lengthRangeReader struct {
+	r        io.Reader
+	n        int64
+	min, max int64
+}
+
+func (l *lengthRangeReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, s3error.S3Error{
+			OriginError: fmt.Errorf("your proposed upload exceeds the maximum allowed size of %d bytes", l.max),
+			Code:        s3error.ErrorCodeEntityTooLarge,
+		}
+	}
+	if err == io.EOF && l.n < l.min {
+		return n, s3error.S3Error{
+			OriginError: fmt.Errorf("your proposed upload is smaller than the minimum allowed size of %d bytes", l.min),
+			Code:        s3error.ErrorCodeEntityTooSmall,
+		}
+	}
+	return n, err
+}
+
+// PostObject stores the file of a multipart/form-data upload. The form is
+// authenticated by the signature of its policy, whose conditions the other
+// fields must meet.
+func (a *S3Proxy) PostObject(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	fields, file, err := readPostForm(r)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	bucket := s3query.DstObj.Bucket
+	fields["bucket"] = bucket
+	if fields["key"] == "" {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeUserKeyMustBeSpecified})
+		return
+	}
+	fields["key"] = strings.ReplaceAll(fields["key"], "${filename}", file.FileName())
+	if a.Auth != nil {
+		cred, err := a.Auth.VerifyPOST(fields)
+		if err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+		if cred != nil {
+			r = r.WithContext(auth.WithCredential(r.Context(), cred))
+		}
+	}
+	var body io.Reader = file
+	if fields["policy"] != "" {
+		policy, err := postpolicy.Parse(fields["policy"])
+		if err == nil {
+			err = policy.Check(fields, time.Now())
+		}
+		if err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+		if policy.HasLengthRange {
+			body = &lengthRangeReader{r: file, min: policy.MinLength, max: policy.MaxLength}
+		}
+	}
+	dst := types.S3Object{Bucket: bucket, Key: fields["key"]}
+	if err := a.checkAccess(r, dst, "s3:PutObject"); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	// The remaining fields are applied like the headers of a PUT.
+	header := http.Header{}
+	for name, value := range fields {
+		if h, ok := postHeaders[name]; ok {
+			header.Set(h, value)
+		} else if strings.HasPrefix(name, userMetaPrefix) || strings.HasPrefix(name, "x-amz-grant-") {
+			header.Set(name, value)
+		}
+	}
+	form := r.Clone(r.Context())
+	form.Header = header
+	meta, err := requestMeta(form)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	input := &backend.PutObjectInput{
+		PutObjectInput: s3.PutObjectInput{
+			Body:          body,
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(dst.Key),
+			ContentLength: -1,
+		},
+		Owner: resourceOwner(r),
+	}
+	meta.putObjectInput(&input.PutObjectInput)
+	out, err := a.Backend.PutObject(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if out.ETag != nil {
+		wr.Header().Set("ETag", aws.ToString(out.ETag))
+	}
+	if out.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(out.VersionId))
+	}
+	writePostResponse(s3query, wr, r, fields, aws.ToString(out.ETag))
+}
+
+// writePostResponse honors success_action_redirect, falling back to
+// success_action_status when it is missing or not a URL. The status
+// defaults to 204, 201 answers with a PostResponse document. Location is
+// addressed the way the form was.
+func writePostResponse(s3query types.S3Query, wr http.ResponseWriter, r *http.Request, fields map[string]string, etag string) {
+	bucket, key := fields["bucket"], fields["key"]
+	if redirect, err := url.Parse(fields["success_action_redirect"]); err == nil && redirect.IsAbs() {
+		query := redirect.Query()
+		query.Set("bucket", bucket)
+		query.Set("key", key)
+		query.Set("etag", etag)
+		redirect.RawQuery = query.Encode()
+		http.Redirect(wr, r, redirect.String(), http.StatusSeeOther)
+		return
+	}
+	location := &url.URL{Scheme: requestScheme(r), Host: r.Host, Path: "/" + bucket + "/" + key}
+	if s3query.VirtualHost {
+		location.Path = "/" + key
+	}
+	wr.Header().Set("Location", location.String())
+	switch fields["success_action_status"] {
+	case "200":
+		wr.WriteHeader(http.StatusOK)
+	case "201":
+		bin, err := xml.Marshal(&types.PostResponse{Location: location.String(), Bucket: bucket, Key: key, ETag: etag})
+		if err != nil {
+			s3error.WriteError(r, wr, err)
+			return
+		}
+		wr.Header().Set("Content-Type", "application/xml")
+		wr.WriteHeader(http.StatusCreated)
+		_, _ = wr.Write(wrapXMLHeader(bin))
+	default:
+		wr.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dashjay/overlay_oss/pkg/auth"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// defaultPresignExpires is the validity of a presigned URL when none is asked.
+const defaultPresignExpires = time.Hour
+
+// presigner mints presigned URLs for the gateway reachable at endpoint,
+// signed with the credential of accessKey.
+type presigner struct {
+	verifier  *auth.Verifier
+	accessKey string
+	endpoint  *url.URL
+}
+
+// presign signs a path-style URL of bucket/key. Only GET and PUT are
+// offered, the methods web frontends hand to browsers.
+func (p *presigner) presign(method, bucket, key string, expires time.Duration) (*url.URL, error) {
+	if method != http.MethodGet && method != http.MethodPut {
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("presigned URLs are only minted for GET and PUT, not '%s'", method),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	if bucket == "" || key == "" {
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("presigned URLs need a bucket and a key"),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	u := *p.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.RawPath = ""
+	return p.verifier.Presign(p.accessKey, method, &u, expires)
+}
+
+// defaultEndpoint guesses the URL clients reach the gateway listening on
+// addr at.
+func defaultEndpoint(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+type presignResponse struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ServeHTTP answers the admin call GET or POST /presign with the query
+// parameters bucket, key, method (GET by default) and expires in seconds.
+// The admin listener does not authenticate, it must only be reachable by
+// trusted frontends.
+func (p *presigner) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/presign" {
+		http.NotFound(wr, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeMethodNotAllowed})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeInvalidArgument})
+		return
+	}
+	method := strings.ToUpper(r.Form.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	expires := defaultPresignExpires
+	if v := r.Form.Get("expires"); v != "" {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			s3error.WriteError(r, wr, s3error.S3Error{
+				OriginError: fmt.Errorf("expires must be a number of seconds"),
+				Code:        s3error.ErrorCodeInvalidArgument,
+			})
+			return
+		}
+		expires = time.Duration(seconds) * time.Second
+	}
+	issued := time.Now()
+	u, err := p.presign(method, r.Form.Get("bucket"), r.Form.Get("key"), expires)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(wr).Encode(&presignResponse{
+		URL:       u.String(),
+		Method:    method,
+		ExpiresAt: issued.Add(expires).UTC().Truncate(time.Second),
+	})
+}
+
+// presignCommand implements "gateway presign [flags] bucket/key", printing
+// a presigned URL without going through a running gateway.
+func presignCommand(args []string) error {
+	fs := flag.NewFlagSet("presign", flag.ExitOnError)
+	credentials := fs.String("credentials", "", "JSON file of access keys, the one of -access-key signs the URL")
+	accessKey := fs.String("access-key", "", "access key to sign with, defaults to the only one in -credentials")
+	endpoint := fs.String("endpoint", "http://localhost:8000", "URL clients reach the gateway at")
+	region := fs.String("region", "", "region of the signature scope, empty uses "+auth.DefaultRegion)
+	method := fs.String("method", http.MethodGet, "HTTP method the URL is valid for, GET or PUT")
+	expires := fs.Duration("expires", defaultPresignExpires, "how long the URL stays valid, at most 7 days")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s presign [flags] bucket/key\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *credentials == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	store, err := auth.LoadCredentials(*credentials)
+	if err != nil {
+		return err
+	}
+	p, err := newPresigner(store, *accessKey, *endpoint, *region)
+	if err != nil {
+		return err
+	}
+	path := strings.SplitN(fs.Arg(0), "/", 2)
+	if len(path) != 2 {
+		return fmt.Errorf("'%s' is not of the form bucket/key", fs.Arg(0))
+	}
+	u, err := p.presign(strings.ToUpper(*method), path[0], path[1], *expires)
+	if err != nil {
+		return err
+	}
+	fmt.Println(u.String())
+	return nil
+}
+
+// newPresigner picks the signing key of store, which may be left empty when
+// the store holds a single credential.
+func newPresigner(store auth.StaticStore, accessKey, endpoint, region string) (*presigner, error) {
+	if accessKey == "" {
+		if len(store) != 1 {
+			return nil, fmt.Errorf("there are %d credentials, choose the signing one by its access key", len(store))
+		}
+		for key := range store {
+			accessKey = key
+		}
+	}
+	if _, err := store.Lookup(accessKey); err != nil {
+		return nil, fmt.Errorf("access key '%s' is not among the credentials", accessKey)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint '%s' is not an absolute URL", endpoint)
+	}
+	verifier := auth.NewVerifier(store)
+	verifier.Region = region
+	return &presigner{verifier: verifier, accessKey: accessKey, endpoint: u}, nil
+}
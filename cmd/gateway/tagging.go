@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+const (
+	// maxTaggingBody bounds the Tagging document.
+	maxTaggingBody    = 64 << 10
+	maxObjectTags     = 10
+	maxBucketTags     = 50
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+func invalidTag(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeInvalidTag}
+}
+
+// validateTags applies the S3 tag limits, lengths count characters.
+func validateTags(tags []types.Tag, max int) error {
+	if len(tags) > max {
+		return invalidTag("tag set cannot have more than %d tags", max)
+	}
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if n := utf8.RuneCountInString(tag.Key); n == 0 || n > maxTagKeyLength {
+			return invalidTag("the TagKey you have provided is invalid")
+		}
+		if utf8.RuneCountInString(tag.Value) > maxTagValueLength {
+			return invalidTag("the TagValue you have provided is invalid")
+		}
+		if strings.HasPrefix(tag.Key, "aws:") {
+			return invalidTag("your TagKey cannot be prefixed with aws:")
+		}
+		if seen[tag.Key] {
+			return invalidTag("cannot provide multiple Tags with the same key")
+		}
+		seen[tag.Key] = true
+	}
+	return nil
+}
+
+// taggingHeader validates the x-amz-tagging header of an upload, it is
+// passed on to the backend in its URL query form.
+func taggingHeader(h http.Header) (*string, error) {
+	v := h.Get("x-amz-tagging")
+	if v == "" {
+		return nil, nil
+	}
+	malformed := s3error.S3Error{
+		OriginError: fmt.Errorf("the header 'x-amz-tagging' shall be encoded as UTF-8 then URLEncoded URL query parameters without tag name duplicates"),
+		Code:        s3error.ErrorCodeInvalidArgument,
+	}
+	values, err := url.ParseQuery(v)
+	if err != nil {
+		return nil, malformed
+	}
+	tags := make([]types.Tag, 0, len(values))
+	for key, vs := range values {
+		if len(vs) > 1 {
+			return nil, malformed
+		}
+		tags = append(tags, types.Tag{Key: key, Value: vs[0]})
+	}
+	if err := validateTags(tags, maxObjectTags); err != nil {
+		return nil, err
+	}
+	return aws.String(v), nil
+}
+
+// decodeTagging reads a Tagging document and checks it against max tags.
+func decodeTagging(r *http.Request, max int) ([]s3types.Tag, error) {
+	var body types.Tagging
+	if err := decodeXML(r, maxTaggingBody, &body, s3error.ErrorCodeMalformedXML); err != nil {
+		return nil, err
+	}
+	if err := validateTags(body.TagSet, max); err != nil {
+		return nil, err
+	}
+	tagSet := make([]s3types.Tag, len(body.TagSet))
+	for i, tag := range body.TagSet {
+		tagSet[i] = s3types.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)}
+	}
+	return tagSet, nil
+}
+
+func writeTagging(wr http.ResponseWriter, r *http.Request, tagSet []s3types.Tag) {
+	result := &types.Tagging{Xmlns: types.S3Namespace, TagSet: make([]types.Tag, len(tagSet))}
+	for i, tag := range tagSet {
+		result.TagSet[i] = types.Tag{Key: aws.ToString(tag.Key), Value: aws.ToString(tag.Value)}
+	}
+	writeXML(wr, r, result)
+}
+
+func (a *S3Proxy) PutObjectTagging(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	tagSet, err := decodeTagging(r, maxObjectTags)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	input := &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s3query.DstObj.Bucket),
+		Key:     aws.String(s3query.DstObj.Key),
+		Tagging: &s3types.Tagging{TagSet: tagSet},
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	out, err := a.Backend.PutObjectTagging(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if out.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(out.VersionId))
+	}
+}
+
+func (a *S3Proxy) GetObjectTagging(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	input := &s3.GetObjectTaggingInput{
+		Bucket: aws.String(s3query.DstObj.Bucket),
+		Key:    aws.String(s3query.DstObj.Key),
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	out, err := a.Backend.GetObjectTagging(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if out.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(out.VersionId))
+	}
+	writeTagging(wr, r, out.TagSet)
+}
+
+func (a *S3Proxy) DeleteObjectTagging(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	input := &s3.DeleteObjectTaggingInput{
+		Bucket: aws.String(s3query.DstObj.Bucket),
+		Key:    aws.String(s3query.DstObj.Key),
+	}
+	if s3query.DstObj.VersionId != "" {
+		input.VersionId = aws.String(s3query.DstObj.VersionId)
+	}
+	out, err := a.Backend.DeleteObjectTagging(input)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if out.VersionId != nil {
+		wr.Header().Set("x-amz-version-id", aws.ToString(out.VersionId))
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (a *S3Proxy) PutBucketTagging(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	tagSet, err := decodeTagging(r, maxBucketTags)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	_, err = a.Backend.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(s3query.DstObj.Bucket),
+		Tagging: &s3types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (a *S3Proxy) GetBucketTagging(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	writeTagging(wr, r, out.TagSet)
+}
+
+func (a *S3Proxy) DeleteBucketTagging(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.DeleteBucketTagging(&s3.DeleteBucketTaggingInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// setTagCount reports how many tags a GET returned object carries.
+func setTagCount(h http.Header, count int32) {
+	if count > 0 {
+		h.Set("x-amz-tagging-count", strconv.Itoa(int(count)))
+	}
+}
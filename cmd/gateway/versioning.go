@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+)
+
+// maxVersioningBody bounds the VersioningConfiguration document.
+const maxVersioningBody = 4 << 10
+
+func (a *S3Proxy) PutBucketVersioning(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	var body types.VersioningConfiguration
+	if err := decodeXML(r, maxVersioningBody, &body, s3error.ErrorCodeMalformedXML); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if body.MfaDelete == "Enabled" {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("MFA delete is not supported"),
+			Code:        s3error.ErrorCodeNotImplemented,
+		})
+		return
+	}
+	_, err := a.Backend.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket: aws.String(s3query.DstObj.Bucket),
+		VersioningConfiguration: &s3types.VersioningConfiguration{
+			Status: s3types.BucketVersioningStatus(body.Status),
+		},
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+}
+
+func (a *S3Proxy) GetBucketVersioning(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	writeXML(wr, r, &types.VersioningConfiguration{Xmlns: types.S3Namespace, Status: string(out.Status)})
+}
+
+func (a *S3Proxy) ListObjectVersions(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	lq := s3query.ListQuery
+	if lq.EncodingType != "" && lq.EncodingType != encodingTypeURL {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("invalid Encoding Method specified in Request"),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		})
+		return
+	}
+	out, err := a.Backend.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket:          aws.String(s3query.DstObj.Bucket),
+		Prefix:          aws.String(lq.Prefix),
+		Delimiter:       aws.String(lq.Delimiter),
+		KeyMarker:       aws.String(lq.KeyMarker),
+		VersionIdMarker: aws.String(lq.VersionIdMarker),
+		MaxKeys:         int32(lq.MaxKeys),
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	encode := func(s string) string {
+		if lq.EncodingType == encodingTypeURL {
+			return s3URLEncode(s)
+		}
+		return s
+	}
+	result := &types.ListVersionsResult{
+		Xmlns:               types.S3Namespace,
+		Name:                s3query.DstObj.Bucket,
+		Prefix:              encode(lq.Prefix),
+		KeyMarker:           encode(lq.KeyMarker),
+		VersionIdMarker:     lq.VersionIdMarker,
+		NextKeyMarker:       encode(aws.ToString(out.NextKeyMarker)),
+		NextVersionIdMarker: aws.ToString(out.NextVersionIdMarker),
+		MaxKeys:             out.MaxKeys,
+		Delimiter:           encode(lq.Delimiter),
+		EncodingType:        lq.EncodingType,
+		IsTruncated:         out.IsTruncated,
+		Entries:             make([]types.VersionEntry, 0, len(out.Entries)),
+	}
+	for _, e := range out.Entries {
+		if m := e.DeleteMarker; m != nil {
+			result.Entries = append(result.Entries, types.VersionEntry{
+				XMLName:      xml.Name{Local: "DeleteMarker"},
+				Key:          encode(aws.ToString(m.Key)),
+				VersionId:    aws.ToString(m.VersionId),
+				IsLatest:     m.IsLatest,
+				LastModified: aws.ToTime(m.LastModified).UTC(),
+			})
+			continue
+		}
+		v := e.Version
+		size := v.Size
+		result.Entries = append(result.Entries, types.VersionEntry{
+			XMLName:      xml.Name{Local: "Version"},
+			Key:          encode(aws.ToString(v.Key)),
+			VersionId:    aws.ToString(v.VersionId),
+			IsLatest:     v.IsLatest,
+			LastModified: aws.ToTime(v.LastModified).UTC(),
+			ETag:         aws.ToString(v.ETag),
+			Size:         &size,
+			StorageClass: string(v.StorageClass),
+		})
+	}
+	for _, cp := range out.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, types.CommonPrefix{Prefix: encode(aws.ToString(cp.Prefix))})
+	}
+	writeXML(wr, r, result)
+}
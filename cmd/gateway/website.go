@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/parse"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/dashjay/overlay_oss/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxRoutingRules = 50
+	// maxWebsiteBody bounds the WebsiteConfiguration document.
+	maxWebsiteBody = 64 << 10
+)
+
+func websiteError(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeInvalidArgument}
+}
+
+func websiteProtocol(protocol string) (s3types.Protocol, error) {
+	switch p := s3types.Protocol(protocol); p {
+	case "", s3types.ProtocolHttp, s3types.ProtocolHttps:
+		return p, nil
+	}
+	return "", websiteError("invalid protocol '%s', must be http or https", protocol)
+}
+
+// routingRule validates rule, which redirects either unconditionally, by
+// key prefix or by the error a request ran into.
+func routingRule(rule *types.RoutingRule) (s3types.RoutingRule, error) {
+	var out s3types.RoutingRule
+	if c := rule.Condition; c != nil {
+		if c.KeyPrefixEquals == "" && c.HttpErrorCodeReturnedEquals == "" {
+			return out, websiteError("a Condition needs KeyPrefixEquals or HttpErrorCodeReturnedEquals")
+		}
+		out.Condition = &s3types.Condition{}
+		if c.KeyPrefixEquals != "" {
+			out.Condition.KeyPrefixEquals = aws.String(c.KeyPrefixEquals)
+		}
+		if code := c.HttpErrorCodeReturnedEquals; code != "" {
+			if n, err := strconv.Atoi(code); err != nil || n < 400 || n > 599 {
+				return out, websiteError("HttpErrorCodeReturnedEquals '%s' is not a 4XX or 5XX code", code)
+			}
+			out.Condition.HttpErrorCodeReturnedEquals = aws.String(code)
+		}
+	}
+	redirect := rule.Redirect
+	if redirect == nil {
+		return out, websiteError("a RoutingRule needs a Redirect")
+	}
+	if redirect.HostName == "" && redirect.HttpRedirectCode == "" && redirect.Protocol == "" &&
+		redirect.ReplaceKeyPrefixWith == nil && redirect.ReplaceKeyWith == nil {
+		return out, websiteError("a Redirect needs at least one of its members")
+	}
+	if redirect.ReplaceKeyPrefixWith != nil && redirect.ReplaceKeyWith != nil {
+		return out, websiteError("ReplaceKeyPrefixWith and ReplaceKeyWith are mutually exclusive")
+	}
+	out.Redirect = &s3types.Redirect{
+		ReplaceKeyPrefixWith: redirect.ReplaceKeyPrefixWith,
+		ReplaceKeyWith:       redirect.ReplaceKeyWith,
+	}
+	if redirect.HostName != "" {
+		out.Redirect.HostName = aws.String(redirect.HostName)
+	}
+	if code := redirect.HttpRedirectCode; code != "" {
+		if n, err := strconv.Atoi(code); err != nil || n < 300 || n > 399 {
+			return out, websiteError("HttpRedirectCode '%s' is not a 3XX code", code)
+		}
+		out.Redirect.HttpRedirectCode = aws.String(code)
+	}
+	var err error
+	out.Redirect.Protocol, err = websiteProtocol(redirect.Protocol)
+	return out, err
+}
+
+// websiteConfiguration validates config, which either redirects every
+// request elsewhere or serves the bucket with an index document.
+func websiteConfiguration(config *types.WebsiteConfiguration) (*s3types.WebsiteConfiguration, error) {
+	out := &s3types.WebsiteConfiguration{}
+	if all := config.RedirectAllRequestsTo; all != nil {
+		if config.IndexDocument != nil || config.ErrorDocument != nil || len(config.RoutingRules) > 0 {
+			return nil, websiteError("RedirectAllRequestsTo cannot be provided in conjunction with other Routing/Redirect configurations")
+		}
+		if all.HostName == "" {
+			return nil, websiteError("RedirectAllRequestsTo needs a HostName")
+		}
+		protocol, err := websiteProtocol(all.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		out.RedirectAllRequestsTo = &s3types.RedirectAllRequestsTo{HostName: aws.String(all.HostName), Protocol: protocol}
+		return out, nil
+	}
+	if config.IndexDocument == nil {
+		return nil, websiteError("a value for IndexDocument Suffix must be provided if RedirectAllRequestsTo is empty")
+	}
+	if suffix := config.IndexDocument.Suffix; suffix == "" || strings.Contains(suffix, "/") {
+		return nil, websiteError("the IndexDocument Suffix '%s' is not well formed", suffix)
+	}
+	out.IndexDocument = &s3types.IndexDocument{Suffix: aws.String(config.IndexDocument.Suffix)}
+	if config.ErrorDocument != nil {
+		if config.ErrorDocument.Key == "" {
+			return nil, websiteError("the ErrorDocument Key must not be empty")
+		}
+		out.ErrorDocument = &s3types.ErrorDocument{Key: aws.String(config.ErrorDocument.Key)}
+	}
+	if len(config.RoutingRules) > maxRoutingRules {
+		return nil, websiteError("a website configuration holds at most %d routing rules", maxRoutingRules)
+	}
+	for i := range config.RoutingRules {
+		rule, err := routingRule(&config.RoutingRules[i])
+		if err != nil {
+			return nil, err
+		}
+		out.RoutingRules = append(out.RoutingRules, rule)
+	}
+	return out, nil
+}
+
+func (a *S3Proxy) PutBucketWebsite(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebsiteBody+1))
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	if len(body) > maxWebsiteBody {
+		s3error.WriteError(r, wr, s3error.S3Error{
+			OriginError: fmt.Errorf("website configuration exceeds %d bytes", maxWebsiteBody),
+			Code:        s3error.ErrorCodeMalformedXML,
+		})
+		return
+	}
+	if err := checkContentMD5(r.Header, body); err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	var config types.WebsiteConfiguration
+	if err := xml.Unmarshal(body, &config); err != nil {
+		s3error.WriteError(r, wr, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeMalformedXML})
+		return
+	}
+	website, err := websiteConfiguration(&config)
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	_, err = a.Backend.PutBucketWebsite(&s3.PutBucketWebsiteInput{
+		Bucket:               aws.String(s3query.DstObj.Bucket),
+		WebsiteConfiguration: website,
+	})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+}
+
+func (a *S3Proxy) GetBucketWebsite(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	out, err := a.Backend.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	result := &types.WebsiteConfiguration{Xmlns: types.S3Namespace}
+	if all := out.RedirectAllRequestsTo; all != nil {
+		result.RedirectAllRequestsTo = &types.RedirectAllRequestsTo{HostName: aws.ToString(all.HostName), Protocol: string(all.Protocol)}
+	}
+	if out.IndexDocument != nil {
+		result.IndexDocument = &types.IndexDocument{Suffix: aws.ToString(out.IndexDocument.Suffix)}
+	}
+	if out.ErrorDocument != nil {
+		result.ErrorDocument = &types.ErrorDocument{Key: aws.ToString(out.ErrorDocument.Key)}
+	}
+	for _, rule := range out.RoutingRules {
+		item := types.RoutingRule{}
+		if c := rule.Condition; c != nil {
+			item.Condition = &types.RoutingRuleCondition{
+				HttpErrorCodeReturnedEquals: aws.ToString(c.HttpErrorCodeReturnedEquals),
+				KeyPrefixEquals:             aws.ToString(c.KeyPrefixEquals),
+			}
+		}
+		if redirect := rule.Redirect; redirect != nil {
+			item.Redirect = &types.RoutingRuleRedirect{
+				HostName:             aws.ToString(redirect.HostName),
+				HttpRedirectCode:     aws.ToString(redirect.HttpRedirectCode),
+				Protocol:             string(redirect.Protocol),
+				ReplaceKeyPrefixWith: redirect.ReplaceKeyPrefixWith,
+				ReplaceKeyWith:       redirect.ReplaceKeyWith,
+			}
+		}
+		result.RoutingRules = append(result.RoutingRules, item)
+	}
+	writeXML(wr, r, result)
+}
+
+func (a *S3Proxy) DeleteBucketWebsite(s3query types.S3Query, wr http.ResponseWriter, r *http.Request) {
+	_, err := a.Backend.DeleteBucketWebsite(&s3.DeleteBucketWebsiteInput{Bucket: aws.String(s3query.DstObj.Bucket)})
+	if err != nil {
+		s3error.WriteError(r, wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// website serves buckets configured as websites to browsers. The bucket is
+// the subdomain of one of domains or else the whole host name, requests are
+// anonymous and errors are HTML pages.
+type website struct {
+	proxy   *S3Proxy
+	domains []string
+}
+
+func (w *website) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		wr.Header().Set("Allow", "GET, HEAD")
+		s3error.WriteHTMLError(r, wr, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeMethodNotAllowed})
+		return
+	}
+	bucket, ok := parse.HostBucket(r.Host, w.domains)
+	if !ok {
+		bucket = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			bucket = host
+		}
+	}
+	config, err := w.proxy.Backend.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		s3error.WriteHTMLError(r, wr, err)
+		return
+	}
+	if all := config.RedirectAllRequestsTo; all != nil {
+		target := &url.URL{Scheme: string(all.Protocol), Host: aws.ToString(all.HostName), Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		if target.Scheme == "" {
+			target.Scheme = requestScheme(r)
+		}
+		http.Redirect(wr, r, target.String(), http.StatusMovedPermanently)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if rule := matchRoutingRule(config.RoutingRules, key, 0); rule != nil {
+		redirectRoutingRule(wr, r, rule, key)
+		return
+	}
+	err = w.serveObject(wr, r, bucket, key, aws.ToString(config.IndexDocument.Suffix))
+	if err == nil {
+		return
+	}
+	status := s3error.StatusCodeOf(err)
+	if rule := matchRoutingRule(config.RoutingRules, key, status); rule != nil {
+		redirectRoutingRule(wr, r, rule, key)
+		return
+	}
+	if config.ErrorDocument != nil && status >= 400 && status < 500 {
+		if w.serveErrorDocument(wr, r, bucket, aws.ToString(config.ErrorDocument.Key), status) {
+			return
+		}
+	}
+	s3error.WriteHTMLError(r, wr, err)
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// getObject fetches key of bucket for an anonymous reader, nothing has been
+// written to wr when it fails.
+func (w *website) getObject(r *http.Request, bucket, key string, ranged bool) (*s3.GetObjectOutput, error) {
+	if err := w.proxy.checkAccess(r, types.S3Object{Bucket: bucket, Key: key}, "s3:GetObject"); err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if v := r.Header.Get("Range"); v != "" && ranged {
+		input.Range = aws.String(v)
+	}
+	return w.proxy.Backend.GetObject(input)
+}
+
+// serveObject writes key, resolving keys that name a directory to its index
+// document. A missing key whose index document exists redirects to the
+// directory, like "docs" to "docs/".
+func (w *website) serveObject(wr http.ResponseWriter, r *http.Request, bucket, key, suffix string) error {
+	target := key
+	if target == "" || strings.HasSuffix(target, "/") {
+		target += suffix
+	}
+	out, err := w.getObject(r, bucket, target, true)
+	if err != nil {
+		if key != "" && !strings.HasSuffix(key, "/") && s3error.IsNoSuchKey(err) {
+			if index, indexErr := w.getObject(r, bucket, key+"/"+suffix, false); indexErr == nil {
+				index.Body.Close()
+				dir := &url.URL{Path: "/" + key + "/", RawQuery: r.URL.RawQuery}
+				http.Redirect(wr, r, dir.String(), http.StatusFound)
+				return nil
+			}
+		}
+		return err
+	}
+	defer out.Body.Close()
+	head := &s3.HeadObjectOutput{
+		ContentLength:      out.ContentLength,
+		LastModified:       out.LastModified,
+		ETag:               out.ETag,
+		ContentType:        out.ContentType,
+		ContentEncoding:    out.ContentEncoding,
+		ContentDisposition: out.ContentDisposition,
+		ContentLanguage:    out.ContentLanguage,
+		CacheControl:       out.CacheControl,
+		Expires:            out.Expires,
+		Metadata:           out.Metadata,
+	}
+	if writePrecondition(wr, r, head) {
+		return nil
+	}
+	setObjectHeaders(wr.Header(), head)
+	if out.ContentRange != nil {
+		wr.Header().Set("Content-Range", aws.ToString(out.ContentRange))
+		wr.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if _, err := io.Copy(wr, out.Body); err != nil {
+		logrus.WithError(err).Warnln("write website object failed")
+	}
+	return nil
+}
+
+// serveErrorDocument answers status with the error document key, reporting
+// false when it cannot be read.
+func (w *website) serveErrorDocument(wr http.ResponseWriter, r *http.Request, bucket, key string, status int) bool {
+	out, err := w.getObject(r, bucket, key, false)
+	if err != nil {
+		return false
+	}
+	defer out.Body.Close()
+	setObjectHeaders(wr.Header(), &s3.HeadObjectOutput{
+		ContentLength:   out.ContentLength,
+		LastModified:    out.LastModified,
+		ETag:            out.ETag,
+		ContentType:     out.ContentType,
+		ContentEncoding: out.ContentEncoding,
+		ContentLanguage: out.ContentLanguage,
+		CacheControl:    out.CacheControl,
+	})
+	wr.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		if _, err := io.Copy(wr, out.Body); err != nil {
+			logrus.WithError(err).Warnln("write website error document failed")
+		}
+	}
+	return true
+}
+
+// matchRoutingRule returns the first rule applying to key. Before the key
+// is read status is 0 and only rules without an error condition apply,
+// afterwards only those naming status.
+func matchRoutingRule(rules []s3types.RoutingRule, key string, status int) *s3types.RoutingRule {
+	for i := range rules {
+		c := rules[i].Condition
+		if c != nil && c.KeyPrefixEquals != nil && !strings.HasPrefix(key, aws.ToString(c.KeyPrefixEquals)) {
+			continue
+		}
+		onError := c != nil && c.HttpErrorCodeReturnedEquals != nil
+		if onError != (status != 0) {
+			continue
+		}
+		if onError && aws.ToString(c.HttpErrorCodeReturnedEquals) != strconv.Itoa(status) {
+			continue
+		}
+		return &rules[i]
+	}
+	return nil
+}
+
+// redirectRoutingRule redirects key the way rule says, members it leaves
+// empty keep their value from the request.
+func redirectRoutingRule(wr http.ResponseWriter, r *http.Request, rule *s3types.RoutingRule, key string) {
+	redirect := rule.Redirect
+	target := &url.URL{Scheme: string(redirect.Protocol), Host: aws.ToString(redirect.HostName), RawQuery: r.URL.RawQuery}
+	if target.Scheme == "" {
+		target.Scheme = requestScheme(r)
+	}
+	if target.Host == "" {
+		target.Host = r.Host
+	}
+	switch {
+	case redirect.ReplaceKeyWith != nil:
+		key = aws.ToString(redirect.ReplaceKeyWith)
+	case redirect.ReplaceKeyPrefixWith != nil:
+		var prefix string
+		if rule.Condition != nil {
+			prefix = aws.ToString(rule.Condition.KeyPrefixEquals)
+		}
+		key = aws.ToString(redirect.ReplaceKeyPrefixWith) + strings.TrimPrefix(key, prefix)
+	}
+	target.Path = "/" + key
+	code := http.StatusMovedPermanently
+	if v := aws.ToString(redirect.HttpRedirectCode); v != "" {
+		code, _ = strconv.Atoi(v)
+	}
+	http.Redirect(wr, r, target.String(), code)
+}
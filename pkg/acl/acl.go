@@ -0,0 +1,207 @@
+// Package acl expands canned ACLs and x-amz-grant-* headers into grants and
+// checks the permissions grants give a requester.
+package acl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// Predefined groups a grant may name by URI.
+const (
+	AllUsers           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	AuthenticatedUsers = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+	LogDelivery        = "http://acs.amazonaws.com/groups/s3/LogDelivery"
+)
+
+// MaxGrants is the largest number of grants an ACL may hold.
+const MaxGrants = 100
+
+// Permissions lists the grantable permissions, FULL_CONTROL implies the
+// others.
+var Permissions = []s3types.Permission{
+	s3types.PermissionFullControl,
+	s3types.PermissionRead,
+	s3types.PermissionWrite,
+	s3types.PermissionReadAcp,
+	s3types.PermissionWriteAcp,
+}
+
+func invalidArgument(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeInvalidArgument}
+}
+
+// UserGrant gives permission to the canonical user id.
+func UserGrant(id, displayName string, permission s3types.Permission) s3types.Grant {
+	grantee := &s3types.Grantee{Type: s3types.TypeCanonicalUser, ID: aws.String(id)}
+	if displayName != "" {
+		grantee.DisplayName = aws.String(displayName)
+	}
+	return s3types.Grant{Grantee: grantee, Permission: permission}
+}
+
+// GroupGrant gives permission to the predefined group uri.
+func GroupGrant(uri string, permission s3types.Permission) s3types.Grant {
+	return s3types.Grant{
+		Grantee:    &s3types.Grantee{Type: s3types.TypeGroup, URI: aws.String(uri)},
+		Permission: permission,
+	}
+}
+
+// Canned expands the canned ACL name for a resource owned by ownerID. The
+// bucket-owner-* ACLs equal private here, objects belong to their writer.
+func Canned(name, ownerID, ownerName string) ([]s3types.Grant, error) {
+	grants := []s3types.Grant{UserGrant(ownerID, ownerName, s3types.PermissionFullControl)}
+	switch s3types.BucketCannedACL(name) {
+	case s3types.BucketCannedACLPrivate:
+	case s3types.BucketCannedACLPublicRead:
+		grants = append(grants, GroupGrant(AllUsers, s3types.PermissionRead))
+	case s3types.BucketCannedACLPublicReadWrite:
+		grants = append(grants,
+			GroupGrant(AllUsers, s3types.PermissionRead),
+			GroupGrant(AllUsers, s3types.PermissionWrite))
+	case s3types.BucketCannedACLAuthenticatedRead:
+		grants = append(grants, GroupGrant(AuthenticatedUsers, s3types.PermissionRead))
+	default:
+		switch s3types.ObjectCannedACL(name) {
+		case s3types.ObjectCannedACLBucketOwnerRead, s3types.ObjectCannedACLBucketOwnerFullControl:
+		case "log-delivery-write":
+			grants = append(grants,
+				GroupGrant(LogDelivery, s3types.PermissionWrite),
+				GroupGrant(LogDelivery, s3types.PermissionReadAcp))
+		default:
+			return nil, invalidArgument("unsupported canned ACL '%s'", name)
+		}
+	}
+	return grants, nil
+}
+
+// ParseGrants parses an x-amz-grant-* header value, a comma separated list
+// of id="...", uri="..." or emailAddress="..." grantees.
+func ParseGrants(permission s3types.Permission, value string) ([]s3types.Grant, error) {
+	var grants []s3types.Grant
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, invalidArgument("malformed grant '%s'", item)
+		}
+		v := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "id":
+			grants = append(grants, UserGrant(v, "", permission))
+		case "uri":
+			if err := checkGroup(v); err != nil {
+				return nil, err
+			}
+			grants = append(grants, GroupGrant(v, permission))
+		case "emailaddress":
+			return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeUnresolvableGrantByEmailAddress}
+		default:
+			return nil, invalidArgument("unknown grantee type '%s'", kv[0])
+		}
+	}
+	return grants, nil
+}
+
+func checkGroup(uri string) error {
+	switch uri {
+	case AllUsers, AuthenticatedUsers, LogDelivery:
+		return nil
+	}
+	return invalidArgument("invalid group uri '%s'", uri)
+}
+
+// FormatGrants renders the grants of permission in the x-amz-grant-* form
+// ParseGrants reads, nil when there are none.
+func FormatGrants(grants []s3types.Grant, permission s3types.Permission) *string {
+	var items []string
+	for _, grant := range grants {
+		if grant.Permission != permission || grant.Grantee == nil {
+			continue
+		}
+		switch grant.Grantee.Type {
+		case s3types.TypeCanonicalUser:
+			items = append(items, fmt.Sprintf("id=%q", aws.ToString(grant.Grantee.ID)))
+		case s3types.TypeGroup:
+			items = append(items, fmt.Sprintf("uri=%q", aws.ToString(grant.Grantee.URI)))
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return aws.String(strings.Join(items, ", "))
+}
+
+// Validate checks grants taken from an AccessControlPolicy document.
+func Validate(grants []s3types.Grant) error {
+	malformed := func(format string, args ...interface{}) error {
+		return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeMalformedACLError}
+	}
+	if len(grants) > MaxGrants {
+		return malformed("an ACL holds at most %d grants", MaxGrants)
+	}
+	for _, grant := range grants {
+		known := false
+		for _, p := range Permissions {
+			known = known || grant.Permission == p
+		}
+		if !known {
+			return malformed("invalid permission '%s'", grant.Permission)
+		}
+		if grant.Grantee == nil {
+			return malformed("a grant needs a grantee")
+		}
+		switch grant.Grantee.Type {
+		case s3types.TypeCanonicalUser:
+			if aws.ToString(grant.Grantee.ID) == "" {
+				return malformed("a CanonicalUser grantee needs an ID")
+			}
+		case s3types.TypeGroup:
+			if err := checkGroup(aws.ToString(grant.Grantee.URI)); err != nil {
+				return malformed("invalid group uri '%s'", aws.ToString(grant.Grantee.URI))
+			}
+		case s3types.TypeAmazonCustomerByEmail:
+			return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeUnresolvableGrantByEmailAddress}
+		default:
+			return malformed("invalid grantee type '%s'", grant.Grantee.Type)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether grants give permission to the requester, whose
+// canonical id is empty when anonymous.
+func Allows(grants []s3types.Grant, requester string, permission s3types.Permission) bool {
+	for _, grant := range grants {
+		if grant.Permission != permission && grant.Permission != s3types.PermissionFullControl {
+			continue
+		}
+		if grant.Grantee == nil {
+			continue
+		}
+		switch grant.Grantee.Type {
+		case s3types.TypeCanonicalUser:
+			if requester != "" && aws.ToString(grant.Grantee.ID) == requester {
+				return true
+			}
+		case s3types.TypeGroup:
+			switch aws.ToString(grant.Grantee.URI) {
+			case AllUsers:
+				return true
+			case AuthenticatedUsers:
+				if requester != "" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+const (
+	// StreamingPayload marks a body sent as signed aws-chunked encoding.
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPrefix  = "STREAMING-"
+	chunkAlgorithm   = "AWS4-HMAC-SHA256-PAYLOAD"
+	awsChunked       = "aws-chunked"
+	emptySHA256      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// unsignedTrailer marks an aws-chunked body whose chunks carry no
+	// signatures, only the request headers are signed.
+	unsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// maxChunkHeader bounds a "<hex-size>;chunk-signature=<sig>" line.
+	maxChunkHeader = 4096
+)
+
+// IsChunked reports whether the body of r is aws-chunked encoded.
+func IsChunked(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get(amzContentSHA256), streamingPrefix) {
+		return true
+	}
+	for _, enc := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if strings.TrimSpace(enc) == awsChunked {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeChunked replaces an aws-chunked body with its decoded payload without
+// checking chunk signatures, for requests that were not authenticated.
+func DecodeChunked(r *http.Request) error {
+	return decodeChunked(r, nil)
+}
+
+// decodeChunked swaps r.Body for a decoding reader and makes the request look
+// as if the payload had been sent plainly: ContentLength becomes
+// x-amz-decoded-content-length and aws-chunked leaves Content-Encoding.
+func decodeChunked(r *http.Request, signer *chunkSigner) error {
+	if !IsChunked(r) {
+		return nil
+	}
+	r.ContentLength = -1
+	if v := r.Header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return s3error.S3Error{
+				OriginError: fmt.Errorf("invalid x-amz-decoded-content-length '%s'", v),
+				Code:        s3error.ErrorCodeInvalidArgument,
+			}
+		}
+		r.ContentLength = n
+	}
+	var encodings []string
+	for _, enc := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if enc = strings.TrimSpace(enc); enc != "" && enc != awsChunked {
+			encodings = append(encodings, enc)
+		}
+	}
+	if len(encodings) == 0 {
+		r.Header.Del("Content-Encoding")
+	} else {
+		r.Header.Set("Content-Encoding", strings.Join(encodings, ","))
+	}
+	r.Body = &chunkedReader{
+		body:   r.Body,
+		r:      bufio.NewReader(r.Body),
+		signer: signer,
+		hash:   sha256.New(),
+	}
+	return nil
+}
+
+// chunkSigner computes the signature chain of a STREAMING-AWS4-HMAC-SHA256-PAYLOAD
+// body, every chunk is signed over the signature of the one before it.
+type chunkSigner struct {
+	key   []byte
+	date  string
+	scope string
+	prev  string
+}
+
+func (s *chunkSigner) next(chunkHash string) string {
+	stringToSign := strings.Join([]string{
+		chunkAlgorithm,
+		s.date,
+		s.scope,
+		s.prev,
+		emptySHA256,
+		chunkHash,
+	}, "\n")
+	s.prev = hex.EncodeToString(hmacSHA256(s.key, []byte(stringToSign)))
+	return s.prev
+}
+
+type chunkedReader struct {
+	body   io.ReadCloser
+	r      *bufio.Reader
+	signer *chunkSigner
+
+	hash      hash.Hash
+	signature string
+	size      int64
+	remaining int64
+	inChunk   bool
+	err       error
+}
+
+func malformedChunk(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeInvalidArgument}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining == 0 {
+		if c.inChunk {
+			if c.err = c.endChunk(); c.err != nil {
+				return 0, c.err
+			}
+		}
+		if c.err = c.beginChunk(); c.err != nil {
+			return 0, c.err
+		}
+		if c.remaining == 0 {
+			// The zero-length chunk terminates the body.
+			if c.err = c.endChunk(); c.err == nil {
+				c.err = c.readTrailers()
+			}
+			if c.err == nil {
+				c.err = io.EOF
+			}
+			return 0, c.err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, part...)
+		if len(line) > maxChunkHeader {
+			return "", malformedChunk("chunk header is too long")
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// beginChunk parses "<hex-size>;chunk-signature=<signature>".
+func (c *chunkedReader) beginChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	fields := strings.SplitN(line, ";", 2)
+	size, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 16, 64)
+	if err != nil || size < 0 {
+		return malformedChunk("malformed chunk size '%s'", fields[0])
+	}
+	c.signature = ""
+	if len(fields) == 2 {
+		kv := strings.SplitN(strings.TrimSpace(fields[1]), "=", 2)
+		if len(kv) == 2 && kv[0] == "chunk-signature" {
+			c.signature = kv[1]
+		}
+	}
+	if c.signer != nil && c.signature == "" {
+		return malformedChunk("chunk is missing its signature")
+	}
+	c.hash.Reset()
+	c.size, c.remaining, c.inChunk = size, size, true
+	return nil
+}
+
+// endChunk consumes the CRLF after the chunk data and checks its signature.
+// The final, empty chunk is followed by trailers instead, see readTrailers.
+func (c *chunkedReader) endChunk() error {
+	c.inChunk = false
+	if c.size > 0 {
+		crlf := make([]byte, 2)
+		if _, err := io.ReadFull(c.r, crlf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if !bytes.Equal(crlf, []byte("\r\n")) {
+			return malformedChunk("chunk data is not followed by CRLF")
+		}
+	}
+	if c.signer == nil {
+		return nil
+	}
+	expected := c.signer.next(hex.EncodeToString(c.hash.Sum(nil)))
+	if !hmac.Equal([]byte(expected), []byte(c.signature)) {
+		return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeSignatureDoesNotMatch}
+	}
+	return nil
+}
+
+// readTrailers skips trailing headers after the final chunk up to the blank
+// line that ends the body.
+func (c *chunkedReader) readTrailers() error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// Some clients end the body right after the final chunk.
+				return nil
+			}
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+	}
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
@@ -0,0 +1,72 @@
+// Package auth verifies AWS Signature Version 4 requests against a local
+// credential store.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+type Credential struct {
+	AccessKey   string `json:"accessKey"`
+	SecretKey   string `json:"secretKey"`
+	DisplayName string `json:"displayName"`
+}
+
+// ID is the canonical user id reported as owner of the requester's resources.
+func (c *Credential) ID() string {
+	return c.AccessKey
+}
+
+type CredentialStore interface {
+	// Lookup returns the credential of accessKey or an InvalidAccessKeyId error.
+	Lookup(accessKey string) (*Credential, error)
+}
+
+// StaticStore is an in-memory CredentialStore keyed by access key.
+type StaticStore map[string]*Credential
+
+func (s StaticStore) Lookup(accessKey string) (*Credential, error) {
+	if cred, ok := s[accessKey]; ok {
+		return cred, nil
+	}
+	return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidAccessKeyId}
+}
+
+// LoadCredentials reads a JSON array of credentials from path.
+func LoadCredentials(path string) (StaticStore, error) {
+	bin, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds []*Credential
+	if err := json.Unmarshal(bin, &creds); err != nil {
+		return nil, err
+	}
+	store := make(StaticStore, len(creds))
+	for _, cred := range creds {
+		if cred.DisplayName == "" {
+			cred.DisplayName = cred.AccessKey
+		}
+		store[cred.AccessKey] = cred
+	}
+	return store, nil
+}
+
+type contextKey int
+
+const credentialKey contextKey = iota
+
+// WithCredential attaches the authenticated requester to ctx.
+func WithCredential(ctx context.Context, cred *Credential) context.Context {
+	return context.WithValue(ctx, credentialKey, cred)
+}
+
+// FromContext returns the authenticated requester, nil for anonymous requests.
+func FromContext(ctx context.Context) *Credential {
+	cred, _ := ctx.Value(credentialKey).(*Credential)
+	return cred
+}
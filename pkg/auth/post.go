@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// VerifyPOST authenticates a browser form upload, whose signature covers the
+// base64 policy field rather than a canonical request. fields are keyed by
+// their lower-cased names. A form without policy is anonymous and yields a
+// nil credential, checking the policy conditions is left to the caller.
+func (v *Verifier) VerifyPOST(fields map[string]string) (*Credential, error) {
+	policy := fields["policy"]
+	if policy == "" {
+		return nil, nil
+	}
+	algorithm := fields[strings.ToLower(amzAlgorithm)]
+	if algorithm != SignV4Algorithm {
+		if fields["awsaccesskeyid"] != "" {
+			return nil, s3error.S3Error{
+				OriginError: fmt.Errorf("only %s signatures are supported", SignV4Algorithm),
+				Code:        s3error.ErrorCodeNotImplemented,
+			}
+		}
+		return nil, malformed("unsupported algorithm '%s'", algorithm)
+	}
+	sig := &signature{}
+	if err := parseCredential(fields[strings.ToLower(amzCredential)], sig); err != nil {
+		return nil, err
+	}
+	date, err := time.Parse(iso8601Format, fields[strings.ToLower(amzDate)])
+	if err != nil {
+		return nil, malformed("malformed x-amz-date '%s'", fields[strings.ToLower(amzDate)])
+	}
+	if sig.scopeDate != date.UTC().Format(yyyymmdd) {
+		return nil, malformed("credential scope date '%s' does not match request date", sig.scopeDate)
+	}
+	if v.Region != "" && sig.region != v.Region {
+		return nil, malformed("the region '%s' is wrong; expecting '%s'", sig.region, v.Region)
+	}
+	cred, err := v.Store.Lookup(sig.accessKey)
+	if err != nil {
+		return nil, err
+	}
+	key := signingKey(cred.SecretKey, sig.scopeDate, sig.region)
+	expected := hex.EncodeToString(hmacSHA256(key, []byte(policy)))
+	if !hmac.Equal([]byte(expected), []byte(fields[strings.ToLower(amzSignature)])) {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeSignatureDoesNotMatch}
+	}
+	return cred, nil
+}
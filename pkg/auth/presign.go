@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// DefaultRegion scopes presigned URLs when the Verifier accepts any region.
+const DefaultRegion = "us-east-1"
+
+// Presign returns u signed with the credential of accessKey for a method
+// request valid for expires. Only the host header is signed and the payload
+// is left unsigned, so any client holding the URL can use it. The signature
+// is computed the way Verify recomputes it.
+func (v *Verifier) Presign(accessKey, method string, u *url.URL, expires time.Duration) (*url.URL, error) {
+	if expires <= 0 || expires > maxPresignExpires || expires%time.Second != 0 {
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("expiry must be a whole number of seconds between 1 and %d", int64(maxPresignExpires/time.Second)),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	cred, err := v.Store.Lookup(accessKey)
+	if err != nil {
+		return nil, err
+	}
+	now := v.now().UTC()
+	sig := &signature{
+		accessKey:     cred.AccessKey,
+		date:          now,
+		scopeDate:     now.Format(yyyymmdd),
+		region:        v.Region,
+		signedHeaders: []string{"host"},
+		payloadHash:   UnsignedPayload,
+		presigned:     true,
+	}
+	if sig.region == "" {
+		sig.region = DefaultRegion
+	}
+	signed := *u
+	query := signed.Query()
+	query.Set(amzAlgorithm, SignV4Algorithm)
+	query.Set(amzCredential, cred.AccessKey+"/"+sig.scope())
+	query.Set(amzDate, now.Format(iso8601Format))
+	query.Set(amzExpires, strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set(amzSignedHeaders, "host")
+	query.Del(amzSignature)
+	signed.RawQuery = query.Encode()
+	r := &http.Request{Method: method, URL: &signed, Host: signed.Host, Header: http.Header{}}
+	query.Set(amzSignature, v.sign(cred, sig, canonicalRequest(r, sig)))
+	signed.RawQuery = query.Encode()
+	return &signed, nil
+}
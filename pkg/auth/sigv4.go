@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+const (
+	SignV4Algorithm = "AWS4-HMAC-SHA256"
+	iso8601Format   = "20060102T150405Z"
+	yyyymmdd        = "20060102"
+	serviceName     = "s3"
+	scopeTerminator = "aws4_request"
+
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	amzAlgorithm     = "X-Amz-Algorithm"
+	amzCredential    = "X-Amz-Credential"
+	amzDate          = "X-Amz-Date"
+	amzExpires       = "X-Amz-Expires"
+	amzSignedHeaders = "X-Amz-SignedHeaders"
+	amzSignature     = "X-Amz-Signature"
+	amzContentSHA256 = "X-Amz-Content-Sha256"
+
+	// maxPresignExpires is the longest validity S3 accepts for a presigned URL.
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// Verifier checks SigV4 signatures carried either in the Authorization header
+// or in the query string of a presigned URL.
+type Verifier struct {
+	Store CredentialStore
+	// Region, when set, is the only region accepted in credential scopes.
+	Region string
+	// MaxSkew bounds the difference between the request date and now.
+	MaxSkew time.Duration
+
+	now func() time.Time
+}
+
+func NewVerifier(store CredentialStore) *Verifier {
+	return &Verifier{Store: store, MaxSkew: 15 * time.Minute, now: time.Now}
+}
+
+// signature is everything parsed out of a signed request that is needed to
+// recompute its signature.
+type signature struct {
+	accessKey     string
+	date          time.Time
+	scopeDate     string
+	region        string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+}
+
+func (s *signature) scope() string {
+	return strings.Join([]string{s.scopeDate, s.region, serviceName, scopeTerminator}, "/")
+}
+
+func malformed(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeAuthorizationHeaderMalformed}
+}
+
+// Verify authenticates r and returns the matching credential. Anonymous
+// requests yield a nil credential and no error. When the payload hash is
+// signed, r.Body is wrapped so reading it fails with BadDigest on mismatch,
+// and aws-chunked bodies are decoded with each chunk signature checked.
+func (v *Verifier) Verify(r *http.Request) (*Credential, error) {
+	var (
+		sig *signature
+		err error
+	)
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), SignV4Algorithm):
+		sig, err = parseAuthorizationHeader(r)
+	case r.URL.Query().Get(amzAlgorithm) != "":
+		sig, err = parsePresignedQuery(r)
+	case r.Header.Get("Authorization") != "":
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("only %s signatures are supported", SignV4Algorithm),
+			Code:        s3error.ErrorCodeNotImplemented,
+		}
+	default:
+		return nil, DecodeChunked(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cred, err := v.check(r, sig)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case sig.payloadHash == StreamingPayload:
+		err = decodeChunked(r, &chunkSigner{
+			key:   signingKey(cred.SecretKey, sig.scopeDate, sig.region),
+			date:  sig.date.UTC().Format(iso8601Format),
+			scope: sig.scope(),
+			prev:  sig.signature,
+		})
+	case !sig.presigned && isHexSHA256(sig.payloadHash):
+		r.Body = &sha256Reader{body: r.Body, hash: sha256.New(), expected: sig.payloadHash}
+	case strings.HasPrefix(sig.payloadHash, streamingPrefix) && sig.payloadHash != unsignedTrailer:
+		// Signed streaming variants other than StreamingPayload, such as
+		// the signed trailer mode, would be decoded without their chunk
+		// signatures being checked.
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("x-amz-content-sha256 '%s' is not supported", sig.payloadHash),
+			Code:        s3error.ErrorCodeNotImplemented,
+		}
+	default:
+		err = DecodeChunked(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (v *Verifier) check(r *http.Request, sig *signature) (*Credential, error) {
+	if v.Region != "" && sig.region != v.Region {
+		return nil, malformed("the region '%s' is wrong; expecting '%s'", sig.region, v.Region)
+	}
+	cred, err := v.Store.Lookup(sig.accessKey)
+	if err != nil {
+		return nil, err
+	}
+	now := v.now()
+	if sig.presigned {
+		expires, err := strconv.ParseInt(r.URL.Query().Get(amzExpires), 10, 64)
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpires {
+			return nil, s3error.S3Error{
+				OriginError: fmt.Errorf("X-Amz-Expires must be between 0 and %d seconds", int64(maxPresignExpires/time.Second)),
+				Code:        s3error.ErrorCodeInvalidArgument,
+			}
+		}
+		if now.Before(sig.date.Add(-v.MaxSkew)) {
+			return nil, s3error.S3Error{OriginError: fmt.Errorf("request is not valid yet"), Code: s3error.ErrorCodeAccessDenied}
+		}
+		if now.After(sig.date.Add(time.Duration(expires) * time.Second)) {
+			return nil, s3error.S3Error{OriginError: fmt.Errorf("request has expired"), Code: s3error.ErrorCodeAccessDenied}
+		}
+	} else if skew := now.Sub(sig.date); skew > v.MaxSkew || skew < -v.MaxSkew {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeRequestTimeTooSkewed}
+	}
+
+	expected := v.sign(cred, sig, canonicalRequest(r, sig))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeSignatureDoesNotMatch}
+	}
+	return cred, nil
+}
+
+func (v *Verifier) sign(cred *Credential, sig *signature, canonical string) string {
+	stringToSign := strings.Join([]string{
+		SignV4Algorithm,
+		sig.date.UTC().Format(iso8601Format),
+		sig.scope(),
+		hexSHA256([]byte(canonical)),
+	}, "\n")
+	key := signingKey(cred.SecretKey, sig.scopeDate, sig.region)
+	return hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+}
+
+func signingKey(secret, date, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(serviceName))
+	return hmacSHA256(key, []byte(scopeTerminator))
+}
+
+// parseCredential splits "AK/20130524/us-east-1/s3/aws4_request".
+func parseCredential(v string, sig *signature) error {
+	fields := strings.Split(v, "/")
+	if len(fields) < 5 {
+		return malformed("malformed credential '%s'", v)
+	}
+	// Access keys may themselves contain slashes, the scope is the tail.
+	n := len(fields)
+	sig.accessKey = strings.Join(fields[:n-4], "/")
+	sig.scopeDate, sig.region = fields[n-4], fields[n-3]
+	if fields[n-2] != serviceName || fields[n-1] != scopeTerminator {
+		return malformed("malformed credential scope '%s'", v)
+	}
+	if sig.accessKey == "" {
+		return malformed("missing access key")
+	}
+	return nil
+}
+
+func parseAuthorizationHeader(r *http.Request) (*signature, error) {
+	sig := &signature{}
+	header := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), SignV4Algorithm))
+	for _, field := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, malformed("malformed authorization field '%s'", field)
+		}
+		switch kv[0] {
+		case "Credential":
+			if err := parseCredential(kv[1], sig); err != nil {
+				return nil, err
+			}
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			sig.signature = kv[1]
+		}
+	}
+	if sig.accessKey == "" || len(sig.signedHeaders) == 0 || sig.signature == "" {
+		return nil, malformed("authorization header is missing Credential, SignedHeaders or Signature")
+	}
+	if err := checkSignedHeaders(r, sig.signedHeaders); err != nil {
+		return nil, err
+	}
+	date, err := requestDate(r)
+	if err != nil {
+		return nil, err
+	}
+	sig.date = date
+	if sig.scopeDate != date.UTC().Format(yyyymmdd) {
+		return nil, malformed("credential scope date '%s' does not match request date", sig.scopeDate)
+	}
+	sig.payloadHash = r.Header.Get(amzContentSHA256)
+	if sig.payloadHash == "" {
+		sig.payloadHash = UnsignedPayload
+	}
+	return sig, nil
+}
+
+// checkSignedHeaders rejects a signature that leaves host or any x-amz-*
+// header of r unsigned, they could be changed without breaking it.
+func checkSignedHeaders(r *http.Request, signed []string) error {
+	set := make(map[string]bool, len(signed))
+	for _, name := range signed {
+		set[name] = true
+	}
+	if !set["host"] {
+		return s3error.S3Error{
+			OriginError: fmt.Errorf("the host header must be signed"),
+			Code:        s3error.ErrorCodeAccessDenied,
+		}
+	}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") && !set[name] {
+			return s3error.S3Error{
+				OriginError: fmt.Errorf("there were headers present in the request which were not signed: %s", name),
+				Code:        s3error.ErrorCodeAccessDenied,
+			}
+		}
+	}
+	return nil
+}
+
+func requestDate(r *http.Request) (time.Time, error) {
+	if v := r.Header.Get(amzDate); v != "" {
+		date, err := time.Parse(iso8601Format, v)
+		if err != nil {
+			return time.Time{}, malformed("malformed X-Amz-Date '%s'", v)
+		}
+		return date, nil
+	}
+	if v := r.Header.Get("Date"); v != "" {
+		date, err := http.ParseTime(v)
+		if err != nil {
+			return time.Time{}, malformed("malformed Date '%s'", v)
+		}
+		return date, nil
+	}
+	return time.Time{}, s3error.S3Error{
+		OriginError: fmt.Errorf("request must carry X-Amz-Date or Date"),
+		Code:        s3error.ErrorCodeMissingSecurityHeader,
+	}
+}
+
+func parsePresignedQuery(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get(amzAlgorithm) != SignV4Algorithm {
+		return nil, malformed("unsupported algorithm '%s'", query.Get(amzAlgorithm))
+	}
+	sig := &signature{presigned: true}
+	if err := parseCredential(query.Get(amzCredential), sig); err != nil {
+		return nil, err
+	}
+	date, err := time.Parse(iso8601Format, query.Get(amzDate))
+	if err != nil {
+		return nil, malformed("malformed X-Amz-Date '%s'", query.Get(amzDate))
+	}
+	sig.date = date
+	if sig.scopeDate != date.UTC().Format(yyyymmdd) {
+		return nil, malformed("credential scope date '%s' does not match request date", sig.scopeDate)
+	}
+	sig.signedHeaders = strings.Split(query.Get(amzSignedHeaders), ";")
+	sig.signature = query.Get(amzSignature)
+	if sig.signature == "" || query.Get(amzSignedHeaders) == "" {
+		return nil, malformed("presigned url is missing X-Amz-SignedHeaders or X-Amz-Signature")
+	}
+	if err := checkSignedHeaders(r, sig.signedHeaders); err != nil {
+		return nil, err
+	}
+	sig.payloadHash = query.Get(amzContentSHA256)
+	if sig.payloadHash == "" {
+		sig.payloadHash = UnsignedPayload
+	}
+	return sig, nil
+}
+
+func canonicalRequest(r *http.Request, sig *signature) string {
+	return strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query(), sig.presigned),
+		canonicalHeaders(r, sig.signedHeaders),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(query url.Values, presigned bool) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if presigned && key == amzSignature {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders renders the signed headers, one "name:value\n" per line.
+func canonicalHeaders(r *http.Request, signed []string) string {
+	var buf strings.Builder
+	for _, name := range signed {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = r.Header.Values("Content-Length")
+			if len(values) == 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		case "transfer-encoding":
+			values = r.TransferEncoding
+		default:
+			values = r.Header.Values(name)
+		}
+		// values may be the request's own slice, trim into a copy.
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(strings.Join(trimmed, ","))
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// uriEncode escapes everything but the RFC 3986 unreserved characters, the
+// way SigV4 expects.
+func uriEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func isHexSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// sha256Reader fails the final read when the body does not hash to the
+// signed x-amz-content-sha256 value.
+type sha256Reader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (s *sha256Reader) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	s.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(s.hash.Sum(nil)) != s.expected {
+		return n, s3error.S3Error{
+			OriginError: fmt.Errorf("the provided x-amz-content-sha256 does not match what was computed"),
+			Code:        s3error.ErrorCodeBadDigest,
+		}
+	}
+	return n, err
+}
+
+func (s *sha256Reader) Close() error {
+	return s.body.Close()
+}
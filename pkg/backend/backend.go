@@ -0,0 +1,138 @@
+// Package backend defines the storage interface S3Proxy dispatches through,
+// so the HTTP layer does not depend on any particular storage engine.
+package backend
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Backend is implemented by every storage driver. Inputs and outputs reuse the
+// aws-sdk-go-v2 s3 types so drivers and handlers speak the same vocabulary.
+type Backend interface {
+	// CreateBucket, PutObject, CopyObject and CreateMultipartUpload store
+	// the access control list given by the Grant* fields, which hold
+	// x-amz-grant-* header values validated by the caller. Canned ACLs
+	// arrive expanded into grants, an ACL without grants is private. They
+	// record Owner as the owner of what they create, CompleteMultipartUpload
+	// passes the owner of the upload on to the object.
+	CreateBucket(input *CreateBucketInput) (*s3.CreateBucketOutput, error)
+	HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
+	DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error)
+	ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
+	PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error)
+	GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error)
+	PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error)
+	// GetBucketTagging fails with NoSuchTagSet when the bucket has no tags.
+	GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error)
+	DeleteBucketTagging(input *s3.DeleteBucketTaggingInput) (*s3.DeleteBucketTaggingOutput, error)
+	// PutBucketLifecycleConfiguration replaces the rules of a bucket, they
+	// have been validated by the caller.
+	PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	// GetBucketLifecycleConfiguration fails with NoSuchLifecycleConfiguration
+	// when the bucket has no rules.
+	GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	DeleteBucketLifecycle(input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error)
+	// PutBucketPolicy stores the policy document as given, it has been
+	// validated by the caller.
+	PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error)
+	// GetBucketPolicy fails with NoSuchBucketPolicy when the bucket has none.
+	GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error)
+	DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error)
+	// PutBucketAcl replaces the grants of a bucket with those of
+	// input.AccessControlPolicy, the owner in the document is ignored.
+	PutBucketAcl(input *s3.PutBucketAclInput) (*s3.PutBucketAclOutput, error)
+	// GetBucketAcl reports the stored grants and owner. Owner is nil when
+	// none was recorded, for buckets created anonymously.
+	GetBucketAcl(input *s3.GetBucketAclInput) (*s3.GetBucketAclOutput, error)
+	// PutBucketCors replaces the CORS rules of a bucket, they have been
+	// validated by the caller.
+	PutBucketCors(input *s3.PutBucketCorsInput) (*s3.PutBucketCorsOutput, error)
+	// GetBucketCors fails with NoSuchCORSConfiguration when the bucket has
+	// no rules.
+	GetBucketCors(input *s3.GetBucketCorsInput) (*s3.GetBucketCorsOutput, error)
+	DeleteBucketCors(input *s3.DeleteBucketCorsInput) (*s3.DeleteBucketCorsOutput, error)
+	// PutBucketWebsite replaces the website configuration of a bucket, it
+	// has been validated by the caller.
+	PutBucketWebsite(input *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error)
+	// GetBucketWebsite fails with NoSuchWebsiteConfiguration when the bucket
+	// is not configured as a website.
+	GetBucketWebsite(input *s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error)
+	DeleteBucketWebsite(input *s3.DeleteBucketWebsiteInput) (*s3.DeleteBucketWebsiteOutput, error)
+
+	// PutObject streams input.Body into storage. A negative ContentLength
+	// means the length is unknown and the body is read until EOF. Tagging,
+	// like on CreateMultipartUpload and CopyObject, is the URL query form
+	// of x-amz-tagging and has been validated by the caller.
+	PutObject(input *PutObjectInput) (*s3.PutObjectOutput, error)
+	GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	// DeleteObject removes input.VersionId when set, otherwise the current
+	// version, leaving a delete marker in versioned buckets. Deleting a
+	// missing key of an unversioned bucket succeeds, as on S3.
+	DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	// CopyObject copies the "bucket/key[?versionId=id]" named by
+	// input.CopySource, which drivers receive unescaped. A set
+	// CopySourceIfMatch must equal the source ETag at the time of the copy.
+	CopyObject(input *CopyObjectInput) (*s3.CopyObjectOutput, error)
+	ListObjects(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+	// ListObjectVersions reports versions and delete markers in Entries
+	// rather than in Versions and DeleteMarkers.
+	ListObjectVersions(input *s3.ListObjectVersionsInput) (*ListObjectVersionsOutput, error)
+	PutObjectTagging(input *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error)
+	GetObjectTagging(input *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error)
+	DeleteObjectTagging(input *s3.DeleteObjectTaggingInput) (*s3.DeleteObjectTaggingOutput, error)
+	// PutObjectAcl and GetObjectAcl work like their bucket counterparts on
+	// the current or the given version. Versions written anonymously and
+	// delete markers have no owner.
+	PutObjectAcl(input *s3.PutObjectAclInput) (*s3.PutObjectAclOutput, error)
+	GetObjectAcl(input *s3.GetObjectAclInput) (*s3.GetObjectAclOutput, error)
+
+	CreateMultipartUpload(input *CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	// UploadPartCopy fills a part from an existing object, optionally limited
+	// to input.CopySourceRange. CopySource is handled as in CopyObject.
+	UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error)
+	// CompleteMultipartUpload composes the listed parts into the final
+	// object, the parts must be in ascending order and match their ETags.
+	CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+	ListParts(input *s3.ListPartsInput) (*s3.ListPartsOutput, error)
+	ListMultipartUploads(input *s3.ListMultipartUploadsInput) (*s3.ListMultipartUploadsOutput, error)
+}
+
+// ListObjectVersionsOutput holds the versions and delete markers of a page in
+// one list in listing order, by key and newest first. The separate lists of
+// the s3 output lose that order between entries of the same second.
+type ListObjectVersionsOutput struct {
+	s3.ListObjectVersionsOutput
+	Entries []VersionEntry
+}
+
+// VersionEntry holds either a version or a delete marker.
+type VersionEntry struct {
+	Version      *s3types.ObjectVersion
+	DeleteMarker *s3types.DeleteMarkerEntry
+}
+
+// CreateBucketInput and the other inputs below add the owner of the created
+// resource, which the s3 inputs have no field for. A nil Owner records none.
+type CreateBucketInput struct {
+	s3.CreateBucketInput
+	Owner *s3types.Owner
+}
+
+type PutObjectInput struct {
+	s3.PutObjectInput
+	Owner *s3types.Owner
+}
+
+type CopyObjectInput struct {
+	s3.CopyObjectInput
+	Owner *s3types.Owner
+}
+
+type CreateMultipartUploadInput struct {
+	s3.CreateMultipartUploadInput
+	Owner *s3types.Owner
+}
@@ -0,0 +1,178 @@
+package sqlite
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/acl"
+)
+
+// storedGrant is the JSON form grants are kept in the acl column of buckets,
+// objects and multipart uploads. An empty column is the private ACL.
+type storedGrant struct {
+	Type        s3types.Type       `json:"type"`
+	ID          string             `json:"id,omitempty"`
+	DisplayName string             `json:"displayName,omitempty"`
+	URI         string             `json:"uri,omitempty"`
+	Permission  s3types.Permission `json:"permission"`
+}
+
+func encodeGrants(grants []s3types.Grant) (string, error) {
+	if len(grants) == 0 {
+		return "", nil
+	}
+	stored := make([]storedGrant, 0, len(grants))
+	for _, grant := range grants {
+		if grant.Grantee == nil {
+			continue
+		}
+		stored = append(stored, storedGrant{
+			Type:        grant.Grantee.Type,
+			ID:          aws.ToString(grant.Grantee.ID),
+			DisplayName: aws.ToString(grant.Grantee.DisplayName),
+			URI:         aws.ToString(grant.Grantee.URI),
+			Permission:  grant.Permission,
+		})
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeGrants(column string) ([]s3types.Grant, error) {
+	if column == "" {
+		return nil, nil
+	}
+	var stored []storedGrant
+	if err := json.Unmarshal([]byte(column), &stored); err != nil {
+		return nil, err
+	}
+	grants := make([]s3types.Grant, len(stored))
+	for i, g := range stored {
+		grantee := &s3types.Grantee{Type: g.Type}
+		if g.ID != "" {
+			grantee.ID = aws.String(g.ID)
+		}
+		if g.DisplayName != "" {
+			grantee.DisplayName = aws.String(g.DisplayName)
+		}
+		if g.URI != "" {
+			grantee.URI = aws.String(g.URI)
+		}
+		grants[i] = s3types.Grant{Grantee: grantee, Permission: g.Permission}
+	}
+	return grants, nil
+}
+
+// encodeGrantHeaders collects the x-amz-grant-* values of a write, in the
+// order of acl.Permissions.
+func encodeGrantHeaders(headers map[s3types.Permission]*string) (string, error) {
+	var grants []s3types.Grant
+	for _, permission := range acl.Permissions {
+		if headers[permission] == nil {
+			continue
+		}
+		parsed, err := acl.ParseGrants(permission, aws.ToString(headers[permission]))
+		if err != nil {
+			return "", err
+		}
+		grants = append(grants, parsed...)
+	}
+	return encodeGrants(grants)
+}
+
+// storedOwner is the owner_id and owner_name columns recording owner.
+func storedOwner(owner *s3types.Owner) (string, string) {
+	if owner == nil {
+		return "", ""
+	}
+	return aws.ToString(owner.ID), aws.ToString(owner.DisplayName)
+}
+
+// ownerOutput reports the owner columns, nil when no owner was recorded.
+func ownerOutput(id, name string) *s3types.Owner {
+	if id == "" {
+		return nil
+	}
+	owner := &s3types.Owner{ID: aws.String(id)}
+	if name != "" {
+		owner.DisplayName = aws.String(name)
+	}
+	return owner
+}
+
+func policyGrants(policy *s3types.AccessControlPolicy) []s3types.Grant {
+	if policy == nil {
+		return nil
+	}
+	return policy.Grants
+}
+
+func (b *Backend) PutBucketAcl(input *s3.PutBucketAclInput) (*s3.PutBucketAclOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	column, err := encodeGrants(policyGrants(input.AccessControlPolicy))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("acl", column).Error; err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketAclOutput{}, nil
+}
+
+func (b *Backend) GetBucketAcl(input *s3.GetBucketAclInput) (*s3.GetBucketAclOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	grants, err := decodeGrants(bucket.ACL)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetBucketAclOutput{Grants: grants, Owner: ownerOutput(bucket.OwnerID, bucket.OwnerName)}, nil
+}
+
+func (b *Backend) PutObjectAcl(input *s3.PutObjectAclInput) (*s3.PutObjectAclOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	column, err := encodeGrants(policyGrants(input.AccessControlPolicy))
+	if err != nil {
+		return nil, err
+	}
+	// UpdateColumn keeps updated_at, an ACL change is no new LastModified.
+	if err := b.DB.Model(obj).UpdateColumn("acl", column).Error; err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectAclOutput{}, nil
+}
+
+func (b *Backend) GetObjectAcl(input *s3.GetObjectAclInput) (*s3.GetObjectAclOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	grants, err := decodeGrants(obj.ACL)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectAclOutput{Grants: grants, Owner: ownerOutput(obj.OwnerID, obj.OwnerName)}, nil
+}
+
+// objectACL is the acl column of an object written with the grant headers.
+func objectACL(fullControl, read, readACP, writeACP *string) (string, error) {
+	return encodeGrantHeaders(map[s3types.Permission]*string{
+		s3types.PermissionFullControl: fullControl,
+		s3types.PermissionRead:        read,
+		s3types.PermissionReadAcp:     readACP,
+		s3types.PermissionWriteAcp:    writeACP,
+	})
+}
@@ -0,0 +1,197 @@
+package sqlite
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/parse"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/pkg/errors"
+)
+
+// splitCopySource splits an unescaped "bucket/key[?versionId=id]" copy
+// source, a leading slash is allowed.
+func splitCopySource(src string) (bucket, key, versionId string, err error) {
+	if i := strings.Index(src, "?versionId="); i >= 0 {
+		src, versionId = src[:i], src[i+len("?versionId="):]
+	}
+	parts := strings.SplitN(strings.TrimPrefix(src, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", s3error.S3Error{
+			OriginError: fmt.Errorf("invalid copy source '%s'", src),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	return parts[0], parts[1], versionId, nil
+}
+
+// findCopySource resolves the source object of a copy. ifMatch is the ETag
+// the caller evaluated its preconditions against; it is rechecked here so a
+// source replaced in the meantime is not copied.
+func (b *Backend) findCopySource(copySource, ifMatch *string) (*Object, error) {
+	bucket, key, versionId, err := splitCopySource(aws.ToString(copySource))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.findBucket(bucket); err != nil {
+		return nil, err
+	}
+	src, err := b.findObjectVersion(bucket, key, versionId)
+	if err != nil {
+		return nil, err
+	}
+	if ifMatch != nil && trimETag(aws.ToString(ifMatch)) != src.ETag {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodePreconditionFailed}
+	}
+	return src, nil
+}
+
+// copyBlob duplicates sec of the blob of src into a new blob and returns its
+// path, size and MD5 digest.
+func (b *Backend) copyBlob(src *Object, sec *section) (string, int64, []byte, error) {
+	r, err := b.openSection(src, sec)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer r.Close()
+	path, n, sum, err := b.putBlob(r, sec.length)
+	if err != nil {
+		return "", 0, nil, errors.Wrapf(err, "copy blob %s", src.BlobPath)
+	}
+	return path, n, sum, nil
+}
+
+func (b *Backend) CopyObject(input *backend.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	src, err := b.findCopySource(input.CopySource, input.CopySourceIfMatch)
+	if err != nil {
+		return nil, err
+	}
+	meta := src.Meta
+	switch input.MetadataDirective {
+	case "", s3types.MetadataDirectiveCopy:
+		if src.BucketName == aws.ToString(input.Bucket) && src.KeyPrefix == aws.ToString(input.Key) && !src.Noncurrent {
+			return nil, s3error.S3Error{
+				OriginError: fmt.Errorf("this copy request is illegal because it is trying to copy an object to itself without changing the object's metadata"),
+				Code:        s3error.ErrorCodeInvalidRequest,
+			}
+		}
+	case s3types.MetadataDirectiveReplace:
+		meta, err = newObjectMeta(input.ContentType, input.ContentEncoding, input.ContentDisposition,
+			input.ContentLanguage, input.CacheControl, input.Expires, input.Metadata)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("unknown metadata directive '%s'", input.MetadataDirective),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	var tags []ObjectTag
+	switch input.TaggingDirective {
+	case "", s3types.TaggingDirectiveCopy:
+		if tags, err = b.objectTags(src); err != nil {
+			return nil, err
+		}
+	case s3types.TaggingDirectiveReplace:
+		if tags, err = parseTagging(input.Tagging); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("unknown tagging directive '%s'", input.TaggingDirective),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	// The ACL is never copied, the copy gets the one of the request.
+	column, err := objectACL(input.GrantFullControl, input.GrantRead, input.GrantReadACP, input.GrantWriteACP)
+	if err != nil {
+		return nil, err
+	}
+	path, n, sum, err := b.copyBlob(src, &section{start: 0, length: src.Size})
+	if err != nil {
+		return nil, err
+	}
+	// The copy is a simple object, its ETag is the MD5 of the content even
+	// when the source was composed from parts.
+	obj := &Object{
+		BucketName: aws.ToString(input.Bucket),
+		KeyPrefix:  aws.ToString(input.Key),
+		BlobPath:   path,
+		Size:       n,
+		ETag:       hex.EncodeToString(sum),
+		Meta:       meta,
+		Tags:       tags,
+		ACL:        column,
+	}
+	// Like its ACL, the copy belongs to the requester, not to the source owner.
+	obj.OwnerID, obj.OwnerName = storedOwner(input.Owner)
+	oldPath, err := b.commitObject(obj)
+	if err != nil {
+		b.removeBlob(path)
+		return nil, err
+	}
+	b.removeBlob(oldPath)
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &s3types.CopyObjectResult{
+			ETag:         obj.etag(),
+			LastModified: aws.Time(obj.UpdatedAt),
+		},
+		CopySourceVersionId: src.outputVersionID(),
+		VersionId:           obj.outputVersionID(),
+	}, nil
+}
+
+func (b *Backend) UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error) {
+	if err := checkPartNumber(input.PartNumber); err != nil {
+		return nil, err
+	}
+	upload, err := b.findUpload(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	src, err := b.findCopySource(input.CopySource, input.CopySourceIfMatch)
+	if err != nil {
+		return nil, err
+	}
+	sec := &section{start: 0, length: src.Size}
+	if input.CopySourceRange != nil {
+		br, err := parse.CopySourceRange(aws.ToString(input.CopySourceRange), src.Size)
+		if err != nil {
+			return nil, err
+		}
+		sec = &section{start: br.Start, length: br.Length}
+	}
+	path, n, sum, err := b.copyBlob(src, sec)
+	if err != nil {
+		return nil, err
+	}
+	part := &Part{
+		UploadId:   upload.UploadId,
+		PartNumber: input.PartNumber,
+		BlobPath:   path,
+		Size:       n,
+		ETag:       hex.EncodeToString(sum),
+	}
+	oldPath, err := b.commitPart(part)
+	if err != nil {
+		b.removeBlob(path)
+		return nil, err
+	}
+	b.removeBlob(oldPath)
+	return &s3.UploadPartCopyOutput{
+		CopySourceVersionId: src.outputVersionID(),
+		CopyPartResult: &s3types.CopyPartResult{
+			ETag:         aws.String(formatETag(part.ETag)),
+			LastModified: aws.Time(part.UpdatedAt),
+		},
+	}, nil
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func (b *Backend) PutBucketCors(input *s3.PutBucketCorsInput) (*s3.PutBucketCorsOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var rules []s3types.CORSRule
+	if input.CORSConfiguration != nil {
+		rules = input.CORSConfiguration.CORSRules
+	}
+	doc, err := json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("cors", string(doc)).Error; err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketCorsOutput{}, nil
+}
+
+// GetBucketCors answers NoSuchCORSConfiguration for a bucket without rules.
+func (b *Backend) GetBucketCors(input *s3.GetBucketCorsInput) (*s3.GetBucketCorsOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if bucket.CORS == "" {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchCORSConfiguration}
+	}
+	var rules []s3types.CORSRule
+	if err := json.Unmarshal([]byte(bucket.CORS), &rules); err != nil {
+		return nil, err
+	}
+	return &s3.GetBucketCorsOutput{CORSRules: rules}, nil
+}
+
+func (b *Backend) DeleteBucketCors(input *s3.DeleteBucketCorsInput) (*s3.DeleteBucketCorsOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("cors", "").Error; err != nil {
+		return nil, err
+	}
+	return &s3.DeleteBucketCorsOutput{}, nil
+}
@@ -0,0 +1,186 @@
+package sqlite
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"gorm.io/gorm"
+)
+
+// Filter kinds of a LifecycleRule, the empty kind is the rule level Prefix
+// of older clients.
+const (
+	filterPrefix = "prefix"
+	filterTag    = "tag"
+	filterAnd    = "and"
+)
+
+// LifecycleRule is one rule of a bucket lifecycle configuration, rows are
+// ordered by id as the rules were given.
+type LifecycleRule struct {
+	ID         uint   `gorm:"primarykey"`
+	BucketName string `gorm:"column:bucket_name;index"`
+	RuleID     string `gorm:"column:rule_id"`
+	Status     string `gorm:"column:status"`
+	Filter     string `gorm:"column:filter"`
+	Prefix     string `gorm:"column:prefix"`
+	// Tags are the tag filter in the URL query form of x-amz-tagging.
+	Tags string `gorm:"column:tags"`
+	// A zero value leaves the corresponding action out of the rule.
+	ExpirationDays            int32      `gorm:"column:expiration_days"`
+	ExpirationDate            *time.Time `gorm:"column:expiration_date"`
+	ExpiredObjectDeleteMarker bool       `gorm:"column:expired_object_delete_marker"`
+	NoncurrentDays            int32      `gorm:"column:noncurrent_days"`
+	NewerNoncurrentVersions   int32      `gorm:"column:newer_noncurrent_versions"`
+	AbortDays                 int32      `gorm:"column:abort_days"`
+}
+
+func encodeTags(tags []s3types.Tag) string {
+	values := url.Values{}
+	for _, tag := range tags {
+		values.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+	return values.Encode()
+}
+
+func lifecycleRuleFrom(bucket string, rule *s3types.LifecycleRule) *LifecycleRule {
+	row := &LifecycleRule{
+		BucketName: bucket,
+		RuleID:     aws.ToString(rule.ID),
+		Status:     string(rule.Status),
+		Prefix:     aws.ToString(rule.Prefix),
+	}
+	switch filter := rule.Filter.(type) {
+	case *s3types.LifecycleRuleFilterMemberPrefix:
+		row.Filter, row.Prefix = filterPrefix, filter.Value
+	case *s3types.LifecycleRuleFilterMemberTag:
+		row.Filter, row.Tags = filterTag, encodeTags([]s3types.Tag{filter.Value})
+	case *s3types.LifecycleRuleFilterMemberAnd:
+		row.Filter, row.Prefix = filterAnd, aws.ToString(filter.Value.Prefix)
+		row.Tags = encodeTags(filter.Value.Tags)
+	}
+	if exp := rule.Expiration; exp != nil {
+		row.ExpirationDays, row.ExpirationDate = exp.Days, exp.Date
+		row.ExpiredObjectDeleteMarker = exp.ExpiredObjectDeleteMarker
+	}
+	if exp := rule.NoncurrentVersionExpiration; exp != nil {
+		row.NoncurrentDays, row.NewerNoncurrentVersions = exp.NoncurrentDays, exp.NewerNoncurrentVersions
+	}
+	if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+		row.AbortDays = abort.DaysAfterInitiation
+	}
+	return row
+}
+
+func (r *LifecycleRule) lifecycleRule() (s3types.LifecycleRule, error) {
+	rule := s3types.LifecycleRule{Status: s3types.ExpirationStatus(r.Status)}
+	if r.RuleID != "" {
+		rule.ID = aws.String(r.RuleID)
+	}
+	tags, err := parseTagging(aws.String(r.Tags))
+	if err != nil {
+		return rule, err
+	}
+	tagSet := make([]s3types.Tag, len(tags))
+	for i := range tags {
+		tagSet[i] = s3types.Tag{Key: aws.String(tags[i].Key), Value: aws.String(tags[i].Value)}
+	}
+	switch r.Filter {
+	case filterPrefix:
+		rule.Filter = &s3types.LifecycleRuleFilterMemberPrefix{Value: r.Prefix}
+	case filterTag:
+		if len(tagSet) > 0 {
+			rule.Filter = &s3types.LifecycleRuleFilterMemberTag{Value: tagSet[0]}
+		}
+	case filterAnd:
+		and := s3types.LifecycleRuleAndOperator{Tags: tagSet}
+		if r.Prefix != "" {
+			and.Prefix = aws.String(r.Prefix)
+		}
+		rule.Filter = &s3types.LifecycleRuleFilterMemberAnd{Value: and}
+	default:
+		rule.Prefix = aws.String(r.Prefix)
+	}
+	if r.ExpirationDays != 0 || r.ExpirationDate != nil || r.ExpiredObjectDeleteMarker {
+		rule.Expiration = &s3types.LifecycleExpiration{
+			Days:                      r.ExpirationDays,
+			Date:                      r.ExpirationDate,
+			ExpiredObjectDeleteMarker: r.ExpiredObjectDeleteMarker,
+		}
+	}
+	if r.NoncurrentDays != 0 {
+		rule.NoncurrentVersionExpiration = &s3types.NoncurrentVersionExpiration{
+			NoncurrentDays:          r.NoncurrentDays,
+			NewerNoncurrentVersions: r.NewerNoncurrentVersions,
+		}
+	}
+	if r.AbortDays != 0 {
+		rule.AbortIncompleteMultipartUpload = &s3types.AbortIncompleteMultipartUpload{DaysAfterInitiation: r.AbortDays}
+	}
+	return rule, nil
+}
+
+// PutBucketLifecycleConfiguration replaces every rule of the bucket.
+func (b *Backend) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var rules []*LifecycleRule
+	if input.LifecycleConfiguration != nil {
+		for i := range input.LifecycleConfiguration.Rules {
+			rules = append(rules, lifecycleRuleFrom(bucket.BucketName, &input.LifecycleConfiguration.Rules[i]))
+		}
+	}
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bucket_name = ?", bucket.BucketName).Delete(&LifecycleRule{}).Error; err != nil {
+			return err
+		}
+		if len(rules) == 0 {
+			return nil
+		}
+		return tx.Create(&rules).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+// GetBucketLifecycleConfiguration answers NoSuchLifecycleConfiguration for a
+// bucket without rules.
+func (b *Backend) GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var rows []LifecycleRule
+	if err := b.DB.Order("id").Find(&rows, "bucket_name = ?", bucket.BucketName).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchLifecycleConfiguration}
+	}
+	out := &s3.GetBucketLifecycleConfigurationOutput{Rules: make([]s3types.LifecycleRule, len(rows))}
+	for i := range rows {
+		if out.Rules[i], err = rows[i].lifecycleRule(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (b *Backend) DeleteBucketLifecycle(input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Where("bucket_name = ?", bucket.BucketName).Delete(&LifecycleRule{}).Error; err != nil {
+		return nil, err
+	}
+	return &s3.DeleteBucketLifecycleOutput{}, nil
+}
@@ -0,0 +1,127 @@
+package sqlite
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// listBatch is how many rows ListObjects reads per query while walking keys.
+const listBatch = 1000
+
+// encodeContinuationToken hides the key a listing resumes after, clients must
+// treat tokens as opaque.
+func encodeContinuationToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeContinuationToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", s3error.S3Error{
+			OriginError: fmt.Errorf("the continuation token provided is incorrect"),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	return string(key), nil
+}
+
+// commonPrefix returns the prefix key rolls up into, or "" when key is listed
+// on its own.
+func commonPrefix(key, prefix, delimiter string) string {
+	if delimiter == "" {
+		return ""
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(prefix)+i+len(delimiter)]
+}
+
+// ListObjects lists keys in byte order after input.StartAfter, or after the
+// key encoded in input.ContinuationToken which takes precedence. Keys sharing
+// a prefix up to the delimiter are rolled up into one CommonPrefixes entry
+// that counts once against MaxKeys.
+func (b *Backend) ListObjects(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	prefix, delimiter := aws.ToString(input.Prefix), aws.ToString(input.Delimiter)
+	marker := aws.ToString(input.StartAfter)
+	if input.ContinuationToken != nil {
+		key, err := decodeContinuationToken(aws.ToString(input.ContinuationToken))
+		if err != nil {
+			return nil, err
+		}
+		marker = key
+	}
+	maxKeys := int(input.MaxKeys)
+	if maxKeys < 0 || maxKeys > maxListLimit {
+		maxKeys = maxListLimit
+	}
+	out := &s3.ListObjectsV2Output{
+		Name:              input.Bucket,
+		Prefix:            input.Prefix,
+		Delimiter:         input.Delimiter,
+		StartAfter:        input.StartAfter,
+		ContinuationToken: input.ContinuationToken,
+		MaxKeys:           int32(maxKeys),
+		Contents:          make([]s3types.Object, 0),
+	}
+
+	var last string
+	cursor := marker
+walk:
+	for {
+		var batch []Object
+		tx := withPrefix(b.DB.Where("bucket_name = ? AND key_prefix > ?", aws.ToString(input.Bucket), cursor), prefix).
+			Where("noncurrent = ? AND delete_marker = ?", false, false)
+		if err := tx.Order("key_prefix").Limit(listBatch).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			key := batch[i].KeyPrefix
+			cursor = key
+			cp := commonPrefix(key, prefix, delimiter)
+			if cp != "" && cp <= marker {
+				// The previous page ended on or inside this prefix.
+				cursor = cp + "\xff"
+				continue walk
+			}
+			if int(out.KeyCount) == maxKeys {
+				out.IsTruncated = maxKeys > 0
+				break walk
+			}
+			out.KeyCount++
+			if cp == "" {
+				last = key
+				out.Contents = append(out.Contents, s3types.Object{
+					Key:          aws.String(key),
+					LastModified: aws.Time(batch[i].UpdatedAt),
+					ETag:         batch[i].etag(),
+					Size:         batch[i].Size,
+					StorageClass: s3types.ObjectStorageClassStandard,
+				})
+				continue
+			}
+			last = cp
+			out.CommonPrefixes = append(out.CommonPrefixes, s3types.CommonPrefix{Prefix: aws.String(cp)})
+			// Every key below cp rolls up into the entry just added.
+			cursor = cp + "\xff"
+			continue walk
+		}
+		if len(batch) < listBatch {
+			break
+		}
+	}
+	if out.IsTruncated {
+		out.NextContinuationToken = aws.String(encodeContinuationToken(last))
+	}
+	return out, nil
+}
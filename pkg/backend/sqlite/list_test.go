@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// listedNames flattens a page into its keys and common prefixes in
+// listing order.
+func listedNames(out *s3.ListObjectsV2Output) []string {
+	var names []string
+	for _, obj := range out.Contents {
+		names = append(names, aws.ToString(obj.Key))
+	}
+	for _, cp := range out.CommonPrefixes {
+		names = append(names, aws.ToString(cp.Prefix))
+	}
+	return names
+}
+
+func TestListObjects(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	for _, key := range []string{"a", "b/1", "b/2", "b/c/3", "c", "d/1", "d/2"} {
+		putObject(t, b, "bucket", key, key)
+	}
+	tests := []struct {
+		name       string
+		prefix     string
+		delimiter  string
+		startAfter string
+		maxKeys    int32
+		want       []string
+		truncated  bool
+	}{
+		{name: "all keys", maxKeys: 1000, want: []string{"a", "b/1", "b/2", "b/c/3", "c", "d/1", "d/2"}},
+		{name: "delimiter", delimiter: "/", maxKeys: 1000, want: []string{"a", "c", "b/", "d/"}},
+		{name: "prefix and delimiter", prefix: "b/", delimiter: "/", maxKeys: 1000, want: []string{"b/1", "b/2", "b/c/"}},
+		{name: "prefix without delimiter", prefix: "b/", maxKeys: 1000, want: []string{"b/1", "b/2", "b/c/3"}},
+		{name: "prefixes count against max keys", delimiter: "/", maxKeys: 2, want: []string{"a", "b/"}, truncated: true},
+		{name: "start after", startAfter: "b/2", maxKeys: 2, want: []string{"b/c/3", "c"}, truncated: true},
+		{name: "start after inside a prefix", delimiter: "/", startAfter: "b/1", maxKeys: 1000, want: []string{"c", "d/"}},
+		{name: "start after a prefix", delimiter: "/", startAfter: "b/", maxKeys: 1000, want: []string{"c", "d/"}},
+		{name: "zero max keys", maxKeys: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := b.ListObjects(&s3.ListObjectsV2Input{
+				Bucket:     aws.String("bucket"),
+				Prefix:     aws.String(tt.prefix),
+				Delimiter:  aws.String(tt.delimiter),
+				StartAfter: aws.String(tt.startAfter),
+				MaxKeys:    tt.maxKeys,
+			})
+			if err != nil {
+				t.Fatalf("ListObjects: %v", err)
+			}
+			names := listedNames(out)
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") || out.IsTruncated != tt.truncated {
+				t.Fatalf("ListObjects = %v truncated %v, want %v truncated %v", names, out.IsTruncated, tt.want, tt.truncated)
+			}
+			if int(out.KeyCount) != len(names) {
+				t.Fatalf("KeyCount = %d, want %d", out.KeyCount, len(names))
+			}
+		})
+	}
+}
+
+func TestListObjectsPages(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	for _, key := range []string{"a", "b/1", "b/2", "b/c/3", "c", "d/1", "d/2"} {
+		putObject(t, b, "bucket", key, key)
+	}
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		maxKeys   int32
+		pages     []string
+	}{
+		{name: "one key per page", maxKeys: 3, pages: []string{"a,b/1,b/2", "b/c/3,c,d/1", "d/2"}},
+		{name: "prefixes across pages", delimiter: "/", maxKeys: 1, pages: []string{"a", "b/", "c", "d/"}},
+		{name: "keys and prefixes on a page", delimiter: "/", maxKeys: 3, pages: []string{"a,c,b/", "d/"}},
+		{name: "nested prefix", prefix: "b/", delimiter: "/", maxKeys: 1, pages: []string{"b/1", "b/2", "b/c/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &s3.ListObjectsV2Input{
+				Bucket:    aws.String("bucket"),
+				Prefix:    aws.String(tt.prefix),
+				Delimiter: aws.String(tt.delimiter),
+				MaxKeys:   tt.maxKeys,
+			}
+			var pages []string
+			for {
+				out, err := b.ListObjects(input)
+				if err != nil {
+					t.Fatalf("ListObjects: %v", err)
+				}
+				pages = append(pages, strings.Join(listedNames(out), ","))
+				if !out.IsTruncated {
+					break
+				}
+				if out.NextContinuationToken == nil || len(pages) > len(tt.pages) {
+					t.Fatalf("pages %q end without a continuation token or do not end", pages)
+				}
+				input.ContinuationToken = out.NextContinuationToken
+			}
+			if strings.Join(pages, "|") != strings.Join(tt.pages, "|") {
+				t.Fatalf("pages = %q, want %q", pages, tt.pages)
+			}
+		})
+	}
+	_, err := b.ListObjects(&s3.ListObjectsV2Input{Bucket: aws.String("bucket"), ContinuationToken: aws.String("!")})
+	if errorCode(err) != s3error.ErrorCodeInvalidArgument {
+		t.Fatalf("ListObjects with a malformed token = %v, want InvalidArgument", err)
+	}
+}
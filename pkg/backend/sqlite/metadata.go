@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ObjectMeta is the system and user metadata stored with an object, embedded
+// in both Object and MultipartUpload so an upload keeps what it was
+// initiated with.
+type ObjectMeta struct {
+	ContentType        string     `gorm:"column:content_type"`
+	ContentEncoding    string     `gorm:"column:content_encoding"`
+	ContentDisposition string     `gorm:"column:content_disposition"`
+	ContentLanguage    string     `gorm:"column:content_language"`
+	CacheControl       string     `gorm:"column:cache_control"`
+	Expires            *time.Time `gorm:"column:expires"`
+	// UserMetadata is the JSON encoded x-amz-meta-* map.
+	UserMetadata string `gorm:"column:user_metadata"`
+}
+
+func newObjectMeta(contentType, contentEncoding, contentDisposition, contentLanguage, cacheControl *string,
+	expires *time.Time, metadata map[string]string) (ObjectMeta, error) {
+	meta := ObjectMeta{
+		ContentType:        aws.ToString(contentType),
+		ContentEncoding:    aws.ToString(contentEncoding),
+		ContentDisposition: aws.ToString(contentDisposition),
+		ContentLanguage:    aws.ToString(contentLanguage),
+		CacheControl:       aws.ToString(cacheControl),
+		Expires:            expires,
+	}
+	if len(metadata) > 0 {
+		bin, err := json.Marshal(metadata)
+		if err != nil {
+			return ObjectMeta{}, err
+		}
+		meta.UserMetadata = string(bin)
+	}
+	return meta, nil
+}
+
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// metadata decodes the x-amz-meta-* map, a corrupt column reads as empty.
+func (m *ObjectMeta) metadata() map[string]string {
+	out := map[string]string{}
+	if m.UserMetadata != "" {
+		json.Unmarshal([]byte(m.UserMetadata), &out)
+	}
+	return out
+}
@@ -0,0 +1,504 @@
+package sqlite
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"gorm.io/gorm"
+)
+
+const (
+	// minPartSize is the smallest size S3 accepts for any part but the last.
+	minPartSize = 5 << 20
+	maxPartID   = 10000
+)
+
+type MultipartUpload struct {
+	gorm.Model
+	UploadId   string     `gorm:"column:upload_id;uniqueIndex"`
+	BucketName string     `gorm:"column:bucket_name;index"`
+	KeyPrefix  string     `gorm:"column:key_prefix"`
+	Meta       ObjectMeta `gorm:"embedded"`
+	// Tagging is the x-amz-tagging query string given at initiation.
+	Tagging string `gorm:"column:tagging"`
+	// ACL holds the grants given at initiation, see storedGrant.
+	ACL string `gorm:"column:acl"`
+	// OwnerID and OwnerName identify the initiator, see Object.
+	OwnerID   string `gorm:"column:owner_id"`
+	OwnerName string `gorm:"column:owner_name"`
+}
+
+type Part struct {
+	gorm.Model
+	UploadId   string `gorm:"column:upload_id;index"`
+	PartNumber int32  `gorm:"column:part_number"`
+	BlobPath   string `gorm:"column:blob_path"`
+	Size       int64  `gorm:"column:size"`
+	ETag       string `gorm:"column:etag"`
+}
+
+func newUploadID() (string, error) {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// formatETag quotes an entity tag the way it appears in HTTP headers and XML.
+func formatETag(etag string) string {
+	return "\"" + etag + "\""
+}
+
+// trimETag strips the quotes clients may or may not send around an ETag.
+func trimETag(etag string) string {
+	return strings.Trim(etag, "\"")
+}
+
+// multipartETag computes the ETag of a composed object: the MD5 of the
+// concatenated binary part digests, suffixed with the number of parts.
+func multipartETag(parts []Part) (string, error) {
+	hash := md5.New()
+	for i := range parts {
+		sum, err := hex.DecodeString(parts[i].ETag)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(parts)), nil
+}
+
+func (b *Backend) findUpload(bucket, key, uploadId string) (*MultipartUpload, error) {
+	var upload MultipartUpload
+	res := b.DB.First(&upload, "upload_id = ? AND bucket_name = ? AND key_prefix = ?", uploadId, bucket, key)
+	if err := res.Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchUpload}
+	}
+	return &upload, nil
+}
+
+func (b *Backend) CreateMultipartUpload(input *backend.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	meta, err := newObjectMeta(input.ContentType, input.ContentEncoding, input.ContentDisposition,
+		input.ContentLanguage, input.CacheControl, input.Expires, input.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	column, err := objectACL(input.GrantFullControl, input.GrantRead, input.GrantReadACP, input.GrantWriteACP)
+	if err != nil {
+		return nil, err
+	}
+	uploadId, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	upload := &MultipartUpload{
+		UploadId:   uploadId,
+		BucketName: aws.ToString(input.Bucket),
+		KeyPrefix:  aws.ToString(input.Key),
+		Meta:       meta,
+		Tagging:    aws.ToString(input.Tagging),
+		ACL:        column,
+	}
+	upload.OwnerID, upload.OwnerName = storedOwner(input.Owner)
+	if err := b.DB.Create(upload).Error; err != nil {
+		return nil, err
+	}
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: aws.String(uploadId),
+	}, nil
+}
+
+func checkPartNumber(partNumber int32) error {
+	if partNumber < 1 || partNumber > maxPartID {
+		return s3error.S3Error{
+			OriginError: fmt.Errorf("part number must be an integer between 1 and %d, inclusive", maxPartID),
+			Code:        s3error.ErrorCodeInvalidArgument,
+		}
+	}
+	return nil
+}
+
+func (b *Backend) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	if err := checkPartNumber(input.PartNumber); err != nil {
+		return nil, err
+	}
+	upload, err := b.findUpload(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	path, n, sum, err := b.putBlob(input.Body, input.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+	part := &Part{
+		UploadId:   upload.UploadId,
+		PartNumber: input.PartNumber,
+		BlobPath:   path,
+		Size:       n,
+		ETag:       hex.EncodeToString(sum),
+	}
+	oldPath, err := b.commitPart(part)
+	if err != nil {
+		b.removeBlob(path)
+		return nil, err
+	}
+	b.removeBlob(oldPath)
+	return &s3.UploadPartOutput{ETag: aws.String(formatETag(part.ETag))}, nil
+}
+
+// commitPart runs commitPart in a transaction of its own.
+func (b *Backend) commitPart(part *Part) (string, error) {
+	var oldPath string
+	err := b.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		oldPath, err = commitPart(tx, part)
+		return err
+	})
+	return oldPath, err
+}
+
+// commitPart stores part, replacing a previous upload of the same part number
+// whose blob path is returned for cleanup. It fails with NoSuchUpload once the
+// upload has been completed or aborted, so no part outlives its upload.
+func commitPart(tx *gorm.DB, part *Part) (string, error) {
+	var uploads int64
+	if err := tx.Model(&MultipartUpload{}).Where("upload_id = ?", part.UploadId).Count(&uploads).Error; err != nil {
+		return "", err
+	}
+	if uploads == 0 {
+		return "", s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchUpload}
+	}
+	var old Part
+	res := tx.First(&old, "upload_id = ? AND part_number = ?", part.UploadId, part.PartNumber)
+	if res.Error != nil && res.Error != gorm.ErrRecordNotFound {
+		return "", res.Error
+	}
+	part.Model = old.Model
+	if err := tx.Save(part).Error; err != nil {
+		return "", err
+	}
+	return old.BlobPath, nil
+}
+
+func (b *Backend) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	upload, err := b.findUpload(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	if input.MultipartUpload == nil || len(input.MultipartUpload.Parts) == 0 {
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("you must specify at least one part"),
+			Code:        s3error.ErrorCodeMalformedXML,
+		}
+	}
+	var uploaded []Part
+	if err := b.DB.Find(&uploaded, "upload_id = ?", upload.UploadId).Error; err != nil {
+		return nil, err
+	}
+	byNumber := make(map[int32]Part, len(uploaded))
+	for i := range uploaded {
+		byNumber[uploaded[i].PartNumber] = uploaded[i]
+	}
+
+	requested := input.MultipartUpload.Parts
+	for i := 1; i < len(requested); i++ {
+		if requested[i].PartNumber <= requested[i-1].PartNumber {
+			return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidPartOrder}
+		}
+	}
+	parts := make([]Part, 0, len(requested))
+	for i := range requested {
+		part, ok := byNumber[requested[i].PartNumber]
+		if !ok || trimETag(aws.ToString(requested[i].ETag)) != part.ETag {
+			return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidPart}
+		}
+		if i < len(requested)-1 && part.Size < minPartSize {
+			return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeEntityTooSmall}
+		}
+		parts = append(parts, part)
+	}
+	etag, err := multipartETag(parts)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := parseTagging(&upload.Tagging)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(parts))
+	for i := range parts {
+		paths[i] = parts[i].BlobPath
+	}
+	body := b.Blobs.OpenConcat(paths)
+	path, n, err := b.Blobs.Put(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{
+		BucketName: upload.BucketName,
+		KeyPrefix:  upload.KeyPrefix,
+		BlobPath:   path,
+		Size:       n,
+		ETag:       etag,
+		PartsCount: int32(len(parts)),
+		Meta:       upload.Meta,
+		Tags:       tags,
+		ACL:        upload.ACL,
+		OwnerID:    upload.OwnerID,
+		OwnerName:  upload.OwnerName,
+	}
+	// The parts were read and concatenated outside the transaction: a part
+	// uploaded again meanwhile fails the completion, and the blobs removed
+	// are those of the parts still recorded when the upload is deleted.
+	var oldPath string
+	var current []Part
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Find(&current, "upload_id = ?", upload.UploadId).Error; err != nil {
+			return err
+		}
+		if err := checkPartsUnchanged(parts, current); err != nil {
+			return err
+		}
+		var err error
+		if oldPath, err = commitObject(tx, obj); err != nil {
+			return err
+		}
+		if err := writeObjectParts(tx, obj, parts); err != nil {
+			return err
+		}
+		return deleteUpload(tx, upload)
+	})
+	if err != nil {
+		b.removeBlob(path)
+		return nil, err
+	}
+	b.removeBlob(oldPath)
+	for i := range current {
+		b.removeBlob(current[i].BlobPath)
+	}
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket:    aws.String(upload.BucketName),
+		Key:       aws.String(upload.KeyPrefix),
+		ETag:      aws.String(formatETag(etag)),
+		Location:  aws.String("/" + upload.BucketName + "/" + upload.KeyPrefix),
+		VersionId: obj.outputVersionID(),
+	}, nil
+}
+
+// checkPartsUnchanged fails with InvalidPart when a part being completed was
+// replaced or removed since it was read.
+func checkPartsUnchanged(parts, current []Part) error {
+	byNumber := make(map[int32]Part, len(current))
+	for i := range current {
+		byNumber[current[i].PartNumber] = current[i]
+	}
+	for i := range parts {
+		part, ok := byNumber[parts[i].PartNumber]
+		if !ok || part.BlobPath != parts[i].BlobPath {
+			return s3error.S3Error{
+				OriginError: fmt.Errorf("part %d was uploaded again during completion", parts[i].PartNumber),
+				Code:        s3error.ErrorCodeInvalidPart,
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Backend) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	upload, err := b.findUpload(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	var parts []Part
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Find(&parts, "upload_id = ?", upload.UploadId).Error; err != nil {
+			return err
+		}
+		return deleteUpload(tx, upload)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range parts {
+		b.removeBlob(parts[i].BlobPath)
+	}
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// deleteUpload drops the bookkeeping rows of an upload, the part blobs are
+// left for the caller to remove. It fails with NoSuchUpload when a concurrent
+// completion or abort deleted the upload first.
+func deleteUpload(tx *gorm.DB, upload *MultipartUpload) error {
+	res := tx.Unscoped().Delete(upload)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchUpload}
+	}
+	return tx.Unscoped().Where("upload_id = ?", upload.UploadId).Delete(&Part{}).Error
+}
+
+// maxListLimit is the largest page S3 hands out for any listing.
+const maxListLimit = 1000
+
+func clampLimit(limit int32) int {
+	if limit <= 0 || limit > maxListLimit {
+		return maxListLimit
+	}
+	return int(limit)
+}
+
+func (b *Backend) ListParts(input *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	upload, err := b.findUpload(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	var marker int64
+	if v := aws.ToString(input.PartNumberMarker); v != "" {
+		if marker, err = strconv.ParseInt(v, 10, 32); err != nil {
+			return nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeInvalidArgument}
+		}
+	}
+	limit := clampLimit(input.MaxParts)
+	var parts []Part
+	err = b.DB.Where("upload_id = ? AND part_number > ?", upload.UploadId, marker).
+		Order("part_number").Limit(limit + 1).Find(&parts).Error
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.ListPartsOutput{
+		Bucket:           aws.String(upload.BucketName),
+		Key:              aws.String(upload.KeyPrefix),
+		UploadId:         aws.String(upload.UploadId),
+		MaxParts:         int32(limit),
+		PartNumberMarker: aws.String(strconv.FormatInt(marker, 10)),
+		StorageClass:     s3types.StorageClassStandard,
+		Parts:            make([]s3types.Part, 0, len(parts)),
+	}
+	if len(parts) > limit {
+		parts, out.IsTruncated = parts[:limit], true
+	}
+	for i := range parts {
+		out.Parts = append(out.Parts, s3types.Part{
+			ETag:         aws.String(formatETag(parts[i].ETag)),
+			LastModified: aws.Time(parts[i].UpdatedAt),
+			PartNumber:   parts[i].PartNumber,
+			Size:         parts[i].Size,
+		})
+	}
+	if len(parts) > 0 {
+		out.NextPartNumberMarker = aws.String(strconv.FormatInt(int64(parts[len(parts)-1].PartNumber), 10))
+	}
+	return out, nil
+}
+
+// ListMultipartUploads lists in-progress uploads ordered by key and then by
+// initiation. An upload-id-marker is only meaningful together with a
+// key-marker and resumes after that upload within the marker key. Keys sharing
+// a prefix up to the delimiter are rolled up into one CommonPrefixes entry
+// that counts once against MaxUploads.
+func (b *Backend) ListMultipartUploads(input *s3.ListMultipartUploadsInput) (*s3.ListMultipartUploadsOutput, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	bucket := aws.ToString(input.Bucket)
+	prefix, delimiter := aws.ToString(input.Prefix), aws.ToString(input.Delimiter)
+	keyMarker, uploadIdMarker := aws.ToString(input.KeyMarker), aws.ToString(input.UploadIdMarker)
+	var markerID uint
+	if keyMarker != "" && uploadIdMarker != "" {
+		var marker MultipartUpload
+		res := b.DB.First(&marker, "upload_id = ? AND key_prefix = ?", uploadIdMarker, keyMarker)
+		if res.Error != nil && res.Error != gorm.ErrRecordNotFound {
+			return nil, res.Error
+		}
+		markerID = marker.ID
+	}
+	limit := clampLimit(input.MaxUploads)
+	out := &s3.ListMultipartUploadsOutput{
+		Bucket:         input.Bucket,
+		Prefix:         input.Prefix,
+		Delimiter:      input.Delimiter,
+		KeyMarker:      input.KeyMarker,
+		UploadIdMarker: input.UploadIdMarker,
+		MaxUploads:     int32(limit),
+		Uploads:        make([]s3types.MultipartUpload, 0),
+	}
+
+	var count int
+	var lastKey, lastUpload string
+	cursorKey, cursorID := keyMarker, markerID
+walk:
+	for {
+		var batch []MultipartUpload
+		tx := withPrefix(b.DB.Where("bucket_name = ?", bucket), prefix)
+		if cursorID != 0 {
+			tx = tx.Where("key_prefix > ? OR (key_prefix = ? AND id > ?)", cursorKey, cursorKey, cursorID)
+		} else {
+			tx = tx.Where("key_prefix > ?", cursorKey)
+		}
+		if err := tx.Order("key_prefix").Order("id").Limit(listBatch).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			upload := &batch[i]
+			cursorKey, cursorID = upload.KeyPrefix, upload.ID
+			cp := commonPrefix(upload.KeyPrefix, prefix, delimiter)
+			if cp != "" && cp <= keyMarker {
+				// The previous page ended on or inside this prefix.
+				cursorKey, cursorID = cp+"\xff", 0
+				continue walk
+			}
+			if count == limit {
+				out.IsTruncated = true
+				break walk
+			}
+			count++
+			if cp != "" {
+				lastKey, lastUpload = cp, ""
+				out.CommonPrefixes = append(out.CommonPrefixes, s3types.CommonPrefix{Prefix: aws.String(cp)})
+				// Every upload below cp rolls up into the entry just added.
+				cursorKey, cursorID = cp+"\xff", 0
+				continue walk
+			}
+			lastKey, lastUpload = upload.KeyPrefix, upload.UploadId
+			out.Uploads = append(out.Uploads, s3types.MultipartUpload{
+				Initiated:    aws.Time(upload.CreatedAt),
+				Key:          aws.String(upload.KeyPrefix),
+				StorageClass: s3types.StorageClassStandard,
+				UploadId:     aws.String(upload.UploadId),
+			})
+		}
+		if len(batch) < listBatch {
+			break
+		}
+	}
+	if out.IsTruncated {
+		out.NextKeyMarker = aws.String(lastKey)
+		if lastUpload != "" {
+			out.NextUploadIdMarker = aws.String(lastUpload)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,317 @@
+package sqlite
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func createUpload(t *testing.T, b *Backend, bucket, key string) string {
+	t.Helper()
+	out, err := b.CreateMultipartUpload(&backend.CreateMultipartUploadInput{CreateMultipartUploadInput: s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload %s/%s: %v", bucket, key, err)
+	}
+	return aws.ToString(out.UploadId)
+}
+
+func uploadPart(t *testing.T, b *Backend, bucket, key, uploadId string, partNumber int32, body string) string {
+	t.Helper()
+	out, err := b.UploadPart(&s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadId),
+		PartNumber:    partNumber,
+		Body:          strings.NewReader(body),
+		ContentLength: int64(len(body)),
+	})
+	if err != nil {
+		t.Fatalf("UploadPart %d: %v", partNumber, err)
+	}
+	return aws.ToString(out.ETag)
+}
+
+func TestCompleteMultipartUpload(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	large := strings.Repeat("a", minPartSize)
+	tests := []struct {
+		name  string
+		parts func(etags map[int32]string) []s3types.CompletedPart
+		code  s3error.ErrorCode
+		body  string
+	}{
+		{
+			name: "complete",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 1, ETag: aws.String(etags[1])}, {PartNumber: 3, ETag: aws.String(etags[3])}}
+			},
+			body: large + "three",
+		},
+		{
+			name: "unquoted etags",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 2, ETag: aws.String(trimETag(etags[2]))}}
+			},
+			body: "two",
+		},
+		{
+			name:  "no parts",
+			parts: func(etags map[int32]string) []s3types.CompletedPart { return nil },
+			code:  s3error.ErrorCodeMalformedXML,
+		},
+		{
+			name: "unknown part",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 1, ETag: aws.String(etags[1])}, {PartNumber: 4, ETag: aws.String(etags[3])}}
+			},
+			code: s3error.ErrorCodeInvalidPart,
+		},
+		{
+			name: "etag mismatch",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 1, ETag: aws.String(etags[1])}, {PartNumber: 3, ETag: aws.String(etags[2])}}
+			},
+			code: s3error.ErrorCodeInvalidPart,
+		},
+		{
+			name: "descending order",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 3, ETag: aws.String(etags[3])}, {PartNumber: 1, ETag: aws.String(etags[1])}}
+			},
+			code: s3error.ErrorCodeInvalidPartOrder,
+		},
+		{
+			name: "repeated part",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 1, ETag: aws.String(etags[1])}, {PartNumber: 1, ETag: aws.String(etags[1])}}
+			},
+			code: s3error.ErrorCodeInvalidPartOrder,
+		},
+		{
+			name: "small part before the last",
+			parts: func(etags map[int32]string) []s3types.CompletedPart {
+				return []s3types.CompletedPart{{PartNumber: 2, ETag: aws.String(etags[2])}, {PartNumber: 3, ETag: aws.String(etags[3])}}
+			},
+			code: s3error.ErrorCodeEntityTooSmall,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadId := createUpload(t, b, "bucket", "key")
+			etags := map[int32]string{
+				1: uploadPart(t, b, "bucket", "key", uploadId, 1, large),
+				2: uploadPart(t, b, "bucket", "key", uploadId, 2, "two"),
+				3: uploadPart(t, b, "bucket", "key", uploadId, 3, "three"),
+			}
+			parts := tt.parts(etags)
+			input := &s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String("bucket"),
+				Key:             aws.String("key"),
+				UploadId:        aws.String(uploadId),
+				MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+			}
+			out, err := b.CompleteMultipartUpload(input)
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("CompleteMultipartUpload = %v, want code %q", err, tt.code)
+			}
+			if err != nil {
+				// A rejected list leaves the upload in place.
+				abort := &s3.AbortMultipartUploadInput{Bucket: input.Bucket, Key: input.Key, UploadId: input.UploadId}
+				if _, err := b.AbortMultipartUpload(abort); err != nil {
+					t.Fatalf("AbortMultipartUpload: %v", err)
+				}
+				return
+			}
+			if want := "-" + strconv.Itoa(len(parts)) + "\""; !strings.HasSuffix(aws.ToString(out.ETag), want) {
+				t.Fatalf("ETag = %s, want suffix %s", aws.ToString(out.ETag), want)
+			}
+			body, err := getObject(t, b, "bucket", "key", "")
+			if err != nil {
+				t.Fatalf("GetObject: %v", err)
+			}
+			if body != tt.body {
+				t.Fatalf("object has %d bytes, want %d", len(body), len(tt.body))
+			}
+			if _, err := b.CompleteMultipartUpload(input); errorCode(err) != s3error.ErrorCodeNoSuchUpload {
+				t.Fatalf("completing twice = %v, want NoSuchUpload", err)
+			}
+		})
+	}
+}
+
+// TestCompletionRaces replays the steps a concurrent UploadPart, Abort or
+// second completion takes after its upload was found.
+func TestCompletionRaces(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	uploadId := createUpload(t, b, "bucket", "key")
+	uploadPart(t, b, "bucket", "key", uploadId, 1, "one")
+	upload, err := b.findUpload("bucket", "key", uploadId)
+	if err != nil {
+		t.Fatalf("findUpload: %v", err)
+	}
+	var parts []Part
+	if err := b.DB.Find(&parts, "upload_id = ?", uploadId).Error; err != nil {
+		t.Fatalf("Find parts: %v", err)
+	}
+
+	uploadPart(t, b, "bucket", "key", uploadId, 1, "uno")
+	var current []Part
+	if err := b.DB.Find(&current, "upload_id = ?", uploadId).Error; err != nil {
+		t.Fatalf("Find parts: %v", err)
+	}
+	if err := checkPartsUnchanged(parts, current); errorCode(err) != s3error.ErrorCodeInvalidPart {
+		t.Fatalf("part uploaded again = %v, want InvalidPart", err)
+	}
+
+	if err := deleteUpload(b.DB, upload); err != nil {
+		t.Fatalf("deleteUpload: %v", err)
+	}
+	if err := deleteUpload(b.DB, upload); errorCode(err) != s3error.ErrorCodeNoSuchUpload {
+		t.Fatalf("deleting twice = %v, want NoSuchUpload", err)
+	}
+	if _, err := b.commitPart(&Part{UploadId: uploadId, PartNumber: 2}); errorCode(err) != s3error.ErrorCodeNoSuchUpload {
+		t.Fatalf("part after deletion = %v, want NoSuchUpload", err)
+	}
+}
+
+func TestListParts(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	uploadId := createUpload(t, b, "bucket", "key")
+	for _, n := range []int32{5, 1, 3, 2, 4} {
+		uploadPart(t, b, "bucket", "key", uploadId, n, "part")
+	}
+	tests := []struct {
+		name      string
+		marker    string
+		maxParts  int32
+		parts     []int32
+		truncated bool
+		next      string
+	}{
+		{name: "all", parts: []int32{1, 2, 3, 4, 5}, next: "5"},
+		{name: "first page", maxParts: 2, parts: []int32{1, 2}, truncated: true, next: "2"},
+		{name: "middle page", marker: "2", maxParts: 2, parts: []int32{3, 4}, truncated: true, next: "4"},
+		{name: "last page", marker: "4", maxParts: 2, parts: []int32{5}, next: "5"},
+		{name: "exact last page", marker: "3", maxParts: 2, parts: []int32{4, 5}, next: "5"},
+		{name: "past the end", marker: "5", maxParts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := b.ListParts(&s3.ListPartsInput{
+				Bucket:           aws.String("bucket"),
+				Key:              aws.String("key"),
+				UploadId:         aws.String(uploadId),
+				PartNumberMarker: aws.String(tt.marker),
+				MaxParts:         tt.maxParts,
+			})
+			if err != nil {
+				t.Fatalf("ListParts: %v", err)
+			}
+			var parts []int32
+			for _, p := range out.Parts {
+				parts = append(parts, p.PartNumber)
+			}
+			if !equalInt32s(parts, tt.parts) || out.IsTruncated != tt.truncated || aws.ToString(out.NextPartNumberMarker) != tt.next {
+				t.Fatalf("ListParts = %v truncated %v next %q, want %v truncated %v next %q",
+					parts, out.IsTruncated, aws.ToString(out.NextPartNumberMarker), tt.parts, tt.truncated, tt.next)
+			}
+		})
+	}
+	_, err := b.ListParts(&s3.ListPartsInput{Bucket: aws.String("bucket"), Key: aws.String("key"), UploadId: aws.String("missing")})
+	if errorCode(err) != s3error.ErrorCodeNoSuchUpload {
+		t.Fatalf("ListParts of a missing upload = %v, want NoSuchUpload", err)
+	}
+}
+
+func equalInt32s(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListMultipartUploads(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	a := createUpload(t, b, "bucket", "a")
+	b1 := createUpload(t, b, "bucket", "b")
+	b2 := createUpload(t, b, "bucket", "b")
+	c := createUpload(t, b, "bucket", "c")
+	d1 := createUpload(t, b, "bucket", "d/1")
+	d2 := createUpload(t, b, "bucket", "d/2")
+	e := createUpload(t, b, "bucket", "e")
+	tests := []struct {
+		name           string
+		prefix         string
+		delimiter      string
+		keyMarker      string
+		uploadIdMarker string
+		maxUploads     int32
+		uploads        []string
+		prefixes       []string
+		nextKey        string
+		nextUploadId   string
+	}{
+		{name: "all", uploads: []string{a, b1, b2, c, d1, d2, e}},
+		{name: "first page", maxUploads: 2, uploads: []string{a, b1}, nextKey: "b", nextUploadId: b1},
+		{name: "page inside a key", keyMarker: "b", uploadIdMarker: b1, maxUploads: 2, uploads: []string{b2, c}, nextKey: "c", nextUploadId: c},
+		{name: "key marker alone skips the key", keyMarker: "b", uploads: []string{c, d1, d2, e}},
+		{name: "unknown upload id marker", keyMarker: "b", uploadIdMarker: "missing", uploads: []string{c, d1, d2, e}},
+		{name: "prefix", prefix: "b", maxUploads: 1, uploads: []string{b1}, nextKey: "b", nextUploadId: b1},
+		{name: "delimiter", delimiter: "/", uploads: []string{a, b1, b2, c, e}, prefixes: []string{"d/"}},
+		{name: "page ends on a prefix", delimiter: "/", keyMarker: "c", maxUploads: 1, prefixes: []string{"d/"}, nextKey: "d/"},
+		{name: "page after a prefix", delimiter: "/", keyMarker: "d/", uploads: []string{e}},
+		{name: "prefix and delimiter", prefix: "d/", delimiter: "/", uploads: []string{d1, d2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := b.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+				Bucket:         aws.String("bucket"),
+				Prefix:         aws.String(tt.prefix),
+				Delimiter:      aws.String(tt.delimiter),
+				KeyMarker:      aws.String(tt.keyMarker),
+				UploadIdMarker: aws.String(tt.uploadIdMarker),
+				MaxUploads:     tt.maxUploads,
+			})
+			if err != nil {
+				t.Fatalf("ListMultipartUploads: %v", err)
+			}
+			var uploads []string
+			for _, u := range out.Uploads {
+				uploads = append(uploads, aws.ToString(u.UploadId))
+			}
+			if strings.Join(uploads, ",") != strings.Join(tt.uploads, ",") {
+				t.Fatalf("uploads = %v, want %v", uploads, tt.uploads)
+			}
+			var prefixes []string
+			for _, cp := range out.CommonPrefixes {
+				prefixes = append(prefixes, aws.ToString(cp.Prefix))
+			}
+			if strings.Join(prefixes, ",") != strings.Join(tt.prefixes, ",") {
+				t.Fatalf("common prefixes = %v, want %v", prefixes, tt.prefixes)
+			}
+			truncated := tt.nextKey != ""
+			if out.IsTruncated != truncated || aws.ToString(out.NextKeyMarker) != tt.nextKey || aws.ToString(out.NextUploadIdMarker) != tt.nextUploadId {
+				t.Fatalf("truncated %v next %q/%q, want %v next %q/%q", out.IsTruncated,
+					aws.ToString(out.NextKeyMarker), aws.ToString(out.NextUploadIdMarker), truncated, tt.nextKey, tt.nextUploadId)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func (b *Backend) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("policy", aws.ToString(input.Policy)).Error; err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketPolicyOutput{}, nil
+}
+
+// GetBucketPolicy answers NoSuchBucketPolicy for a bucket without policy.
+func (b *Backend) GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if bucket.Policy == "" {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchBucketPolicy}
+	}
+	return &s3.GetBucketPolicyOutput{Policy: aws.String(bucket.Policy)}, nil
+}
+
+func (b *Backend) DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("policy", "").Error; err != nil {
+		return nil, err
+	}
+	return &s3.DeleteBucketPolicyOutput{}, nil
+}
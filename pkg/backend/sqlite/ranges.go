@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dashjay/overlay_oss/pkg/parse"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"gorm.io/gorm"
+)
+
+// ObjectPart records where each part of a completed multipart upload landed
+// inside the composed blob, so parts can be read back by number.
+type ObjectPart struct {
+	ID         uint  `gorm:"primarykey"`
+	ObjectID   uint  `gorm:"column:object_id;index"`
+	PartNumber int32 `gorm:"column:part_number"`
+	Offset     int64 `gorm:"column:offset"`
+	Size       int64 `gorm:"column:size"`
+}
+
+// section is the slice of an object a read should return.
+type section struct {
+	start, length int64
+	// contentRange is empty when the whole object is returned.
+	contentRange string
+}
+
+// objectSection resolves a Range header or a partNumber against obj, at most
+// one of them may be given.
+func (b *Backend) objectSection(obj *Object, rangeHeader string, partNumber int32) (*section, error) {
+	whole := &section{start: 0, length: obj.Size}
+	if partNumber != 0 {
+		if rangeHeader != "" {
+			return nil, s3error.S3Error{
+				OriginError: fmt.Errorf("cannot specify both Range header and partNumber query parameter"),
+				Code:        s3error.ErrorCodeInvalidArgument,
+			}
+		}
+		if partNumber < 0 || partNumber > maxPartID {
+			return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidArgument}
+		}
+		if obj.PartsCount == 0 {
+			// A simple upload behaves like an object of one part.
+			if partNumber != 1 {
+				return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidPartNumber}
+			}
+			return whole, nil
+		}
+		var part ObjectPart
+		res := b.DB.First(&part, "object_id = ? AND part_number = ?", obj.ID, partNumber)
+		if res.Error != nil {
+			if res.Error != gorm.ErrRecordNotFound {
+				return nil, res.Error
+			}
+			return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidPartNumber}
+		}
+		sec := &section{start: part.Offset, length: part.Size}
+		sec.contentRange = (&parse.ByteRange{Start: sec.start, Length: sec.length}).ContentRange(obj.Size)
+		return sec, nil
+	}
+	br, err := parse.Range(rangeHeader, obj.Size)
+	if err != nil || br == nil {
+		return whole, err
+	}
+	return &section{start: br.Start, length: br.Length, contentRange: br.ContentRange(obj.Size)}, nil
+}
+
+// openSection opens the blob of obj limited to sec.
+func (b *Backend) openSection(obj *Object, sec *section) (io.ReadCloser, error) {
+	if obj.BlobPath == "" {
+		return nil, fmt.Errorf("object '%s/%s' has no payload blob", obj.BucketName, obj.KeyPrefix)
+	}
+	f, err := b.Blobs.Open(obj.BlobPath)
+	if err != nil {
+		return nil, err
+	}
+	if sec.start == 0 && sec.length == obj.Size {
+		return f, nil
+	}
+	return &sectionReadCloser{SectionReader: io.NewSectionReader(f, sec.start, sec.length), f: f}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.f.Close()
+}
+
+// writeObjectParts records the layout of a composed object, parts are
+// renumbered from one in the order they were completed.
+func writeObjectParts(tx *gorm.DB, obj *Object, parts []Part) error {
+	layout := make([]ObjectPart, len(parts))
+	var offset int64
+	for i := range parts {
+		layout[i] = ObjectPart{ObjectID: obj.ID, PartNumber: int32(i + 1), Offset: offset, Size: parts[i].Size}
+		offset += parts[i].Size
+	}
+	return tx.CreateInBatches(layout, 100).Error
+}
+
+func deleteObjectParts(tx *gorm.DB, obj *Object) error {
+	return tx.Where("object_id = ?", obj.ID).Delete(&ObjectPart{}).Error
+}
@@ -0,0 +1,557 @@
+// Package sqlite is a backend.Backend driver that keeps buckets and objects
+// in a SQLite database through gorm.
+package sqlite
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/blob"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	gormsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type Bucket struct {
+	gorm.Model
+	BucketName string `gorm:"column=bucket_name"`
+	// Versioning is empty until versioning is first configured, then
+	// Enabled or Suspended.
+	Versioning string `gorm:"column:versioning"`
+	// Policy is the JSON bucket policy document, empty without a policy.
+	Policy string `gorm:"column:policy"`
+	// ACL holds the grants of the bucket, see storedGrant.
+	ACL string `gorm:"column:acl"`
+	// OwnerID and OwnerName identify the creator, both are empty when the
+	// bucket was created anonymously.
+	OwnerID   string `gorm:"column:owner_id"`
+	OwnerName string `gorm:"column:owner_name"`
+	// CORS is the JSON list of CORS rules, empty without a configuration.
+	CORS string `gorm:"column:cors"`
+	// Website is the JSON website configuration, empty when the bucket is
+	// not served as a website.
+	Website string `gorm:"column:website"`
+}
+
+type Object struct {
+	gorm.Model
+	BucketName string `gorm:"column=bucket_name"`
+	KeyPrefix  string `gorm:"column=key_prefix"`
+	// BlobPath is relative to the blob store root, the payload itself
+	// lives on disk rather than in the database.
+	BlobPath string `gorm:"column:blob_path"`
+	Size     int64  `gorm:"column:size"`
+	// ETag is the unquoted entity tag, see formatETag.
+	ETag string `gorm:"column:etag"`
+	// PartsCount is the number of parts of a multipart object, zero for
+	// simple uploads.
+	PartsCount int32      `gorm:"column:parts_count"`
+	Meta       ObjectMeta `gorm:"embedded"`
+	// VersionId is empty for the null version, the one written while
+	// versioning is not enabled.
+	VersionId string `gorm:"column:version_id"`
+	// Noncurrent marks versions superseded by a later write or delete
+	// marker, every key has at most one current row.
+	Noncurrent   bool `gorm:"column:noncurrent"`
+	DeleteMarker bool `gorm:"column:delete_marker"`
+	// ACL holds the grants of the version, see storedGrant.
+	ACL string `gorm:"column:acl"`
+	// OwnerID and OwnerName identify the writer, both are empty for
+	// anonymous writes and delete markers.
+	OwnerID   string `gorm:"column:owner_id"`
+	OwnerName string `gorm:"column:owner_name"`
+	// Tags are written along with a new object by commitObject, they are
+	// not loaded when an object is read.
+	Tags []ObjectTag `gorm:"-"`
+}
+
+// etag returns the quoted entity tag, nil for objects stored before ETags
+// were recorded.
+func (o *Object) etag() *string {
+	if o.ETag == "" {
+		return nil
+	}
+	return aws.String(formatETag(o.ETag))
+}
+
+// versionID is the version id reported for o, "null" for the null version.
+func (o *Object) versionID() string {
+	if o.VersionId == "" {
+		return nullVersionID
+	}
+	return o.VersionId
+}
+
+// outputVersionID is set on write and read outputs only for objects written
+// with versioning enabled.
+func (o *Object) outputVersionID() *string {
+	if o.VersionId == "" {
+		return nil
+	}
+	return aws.String(o.VersionId)
+}
+
+type Backend struct {
+	DB    *gorm.DB
+	Blobs *blob.Store
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// New opens (or creates) the SQLite database at dsn and migrates the schema,
+// object payloads are kept in the content directory dataDir.
+func New(dsn string, dataDir string) (*Backend, error) {
+	blobs, err := blob.NewStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(gormsqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infoln("start migrating")
+	// Migrate the schema
+	if err := db.AutoMigrate(&Bucket{}, &Object{}, &MultipartUpload{}, &Part{}, &ObjectPart{}, &ObjectTag{}, &BucketTag{}, &LifecycleRule{}); err != nil {
+		return nil, err
+	}
+	if err := migrateInlineData(db, blobs); err != nil {
+		return nil, err
+	}
+	logrus.Infoln("migrated")
+	return &Backend{DB: db, Blobs: blobs}, nil
+}
+
+// migrateInlineData moves the payloads of objects written before the blob
+// store, which kept them in the data column, into blobs. Those rows predate
+// every later column, which AutoMigrate left NULL, so they are filled in
+// with the values of a plain unversioned object. Moved rows get their data
+// cleared so the migration runs once per row.
+func migrateInlineData(db *gorm.DB, blobs *blob.Store) error {
+	if !db.Migrator().HasColumn(&Object{}, "data") {
+		return nil
+	}
+	var ids []uint
+	err := db.Model(&Object{}).Where("blob_path IS NULL AND data IS NOT NULL").Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var data []byte
+		if err := db.Model(&Object{}).Where("id = ?", id).Select("data").Row().Scan(&data); err != nil {
+			return err
+		}
+		path, n, err := blobs.Put(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(data)
+		err = db.Model(&Object{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+			"blob_path":     path,
+			"size":          n,
+			"etag":          hex.EncodeToString(sum[:]),
+			"parts_count":   0,
+			"version_id":    "",
+			"noncurrent":    false,
+			"delete_marker": false,
+			"acl":           "",
+			"data":          nil,
+		}).Error
+		if err != nil {
+			blobs.Remove(path)
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		logrus.WithField("objects", len(ids)).Infoln("moved inline payloads into the blob store")
+	}
+	return nil
+}
+
+// removeBlob deletes a blob that is no longer referenced. Failures only leak
+// disk space, so they are logged rather than returned.
+func (b *Backend) removeBlob(path string) {
+	if err := b.Blobs.Remove(path); err != nil {
+		logrus.WithError(err).WithField("blob", path).Warnln("remove blob failed")
+	}
+}
+
+func (b *Backend) findBucket(name string) (*Bucket, error) {
+	var bucket Bucket
+	res := b.DB.First(&bucket, "bucket_name = ?", name)
+	if err := res.Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchBucket}
+	}
+	return &bucket, nil
+}
+
+// findObject returns the current version of key, a key whose current
+// version is a delete marker does not exist.
+func (b *Backend) findObject(bucket, key string) (*Object, error) {
+	var obj Object
+	res := b.DB.First(&obj, "bucket_name = ? AND key_prefix = ? AND noncurrent = ?", bucket, key, false)
+	if err := res.Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		return nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeNoSuchKey}
+	}
+	if obj.DeleteMarker {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchKey}
+	}
+	return &obj, nil
+}
+
+func (b *Backend) CreateBucket(input *backend.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	if aws.ToString(input.Bucket) == "" {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidArgument}
+	}
+	_, err := b.findBucket(aws.ToString(input.Bucket))
+	if err == nil {
+		return nil, s3error.S3Error{
+			OriginError: nil,
+			Code:        s3error.ErrorCodeBucketAlreadyExists,
+		}
+	}
+	if !s3error.IsS3Error(err, s3error.ErrorCodeNoSuchBucket) {
+		return nil, err
+	}
+	column, err := encodeGrantHeaders(map[s3types.Permission]*string{
+		s3types.PermissionFullControl: input.GrantFullControl,
+		s3types.PermissionRead:        input.GrantRead,
+		s3types.PermissionReadAcp:     input.GrantReadACP,
+		s3types.PermissionWrite:       input.GrantWrite,
+		s3types.PermissionWriteAcp:    input.GrantWriteACP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	bucket := &Bucket{BucketName: aws.ToString(input.Bucket), ACL: column}
+	bucket.OwnerID, bucket.OwnerName = storedOwner(input.Owner)
+	if err := b.DB.Create(bucket).Error; err != nil {
+		return nil, err
+	}
+	return &s3.CreateBucketOutput{Location: aws.String("/" + aws.ToString(input.Bucket))}, nil
+}
+
+func (b *Backend) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (b *Backend) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := b.DB.Model(&Object{}).Where("bucket_name = ?", bucket.BucketName).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		// Unfinished multipart uploads keep a bucket in use as well.
+		if err := b.DB.Model(&MultipartUpload{}).Where("bucket_name = ?", bucket.BucketName).Count(&count).Error; err != nil {
+			return nil, err
+		}
+	}
+	if count > 0 {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeBucketNotEmpty}
+	}
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bucket_name = ?", bucket.BucketName).Delete(&BucketTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("bucket_name = ?", bucket.BucketName).Delete(&LifecycleRule{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(bucket).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+func (b *Backend) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	var buckets []Bucket
+	if err := b.DB.Order("bucket_name").Find(&buckets).Error; err != nil {
+		return nil, err
+	}
+	var outBuckets = make([]s3types.Bucket, 0, len(buckets))
+	for i := range buckets {
+		outBuckets = append(outBuckets, s3types.Bucket{
+			CreationDate: aws.Time(buckets[i].CreatedAt),
+			Name:         aws.String(buckets[i].BucketName),
+		})
+	}
+	return &s3.ListBucketsOutput{Buckets: outBuckets}, nil
+}
+
+func (b *Backend) PutObject(input *backend.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	meta, err := newObjectMeta(input.ContentType, input.ContentEncoding, input.ContentDisposition,
+		input.ContentLanguage, input.CacheControl, input.Expires, input.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := parseTagging(input.Tagging)
+	if err != nil {
+		return nil, err
+	}
+	column, err := objectACL(input.GrantFullControl, input.GrantRead, input.GrantReadACP, input.GrantWriteACP)
+	if err != nil {
+		return nil, err
+	}
+	path, n, sum, err := b.putBlob(input.Body, input.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{
+		BucketName: aws.ToString(input.Bucket),
+		KeyPrefix:  aws.ToString(input.Key),
+		BlobPath:   path,
+		Size:       n,
+		ETag:       hex.EncodeToString(sum),
+		Meta:       meta,
+		Tags:       tags,
+		ACL:        column,
+	}
+	obj.OwnerID, obj.OwnerName = storedOwner(input.Owner)
+	oldPath, err := b.commitObject(obj)
+	if err != nil {
+		b.removeBlob(path)
+		return nil, err
+	}
+	b.removeBlob(oldPath)
+	return &s3.PutObjectOutput{ETag: obj.etag(), VersionId: obj.outputVersionID()}, nil
+}
+
+// withPrefix restricts a query to keys starting with prefix. LIKE is avoided
+// because SQLite matches it case-insensitively and gives % and _ meaning.
+func withPrefix(tx *gorm.DB, prefix string) *gorm.DB {
+	if prefix == "" {
+		return tx
+	}
+	return tx.Where("substr(key_prefix, 1, length(?)) = ?", prefix, prefix)
+}
+
+// commitObject makes obj the current version of its key. With versioning
+// enabled it is added as a new version, otherwise it replaces the null
+// version whose blob path is returned so the caller can remove it once the
+// surrounding transaction has committed.
+func commitObject(tx *gorm.DB, obj *Object) (string, error) {
+	var bucket Bucket
+	if err := tx.First(&bucket, "bucket_name = ?", obj.BucketName).Error; err != nil {
+		return "", err
+	}
+	// UpdateColumn leaves updated_at alone, it is the LastModified of the
+	// version and dates when the next one became noncurrent.
+	err := tx.Model(&Object{}).
+		Where("bucket_name = ? AND key_prefix = ? AND noncurrent = ?", obj.BucketName, obj.KeyPrefix, false).
+		UpdateColumn("noncurrent", true).Error
+	if err != nil {
+		return "", err
+	}
+	obj.Noncurrent = false
+	var oldPath string
+	if bucket.Versioning == string(s3types.BucketVersioningStatusEnabled) {
+		if obj.VersionId, err = newVersionID(); err != nil {
+			return "", err
+		}
+	} else {
+		obj.VersionId = ""
+		var old Object
+		res := tx.First(&old, "bucket_name = ? AND key_prefix = ? AND version_id = ?", obj.BucketName, obj.KeyPrefix, "")
+		if res.Error != nil && res.Error != gorm.ErrRecordNotFound {
+			return "", res.Error
+		}
+		if res.Error == nil {
+			// The replacement gets a fresh row so ids keep following
+			// write order, which version listings rely on.
+			if err := deleteObjectRow(tx, &old); err != nil {
+				return "", err
+			}
+			oldPath = old.BlobPath
+		}
+	}
+	if err := tx.Create(obj).Error; err != nil {
+		return "", err
+	}
+	return oldPath, writeObjectTags(tx, obj)
+}
+
+// deleteObjectRow removes the row of one object version together with the
+// rows that hang off it. The blob is left to the caller.
+func deleteObjectRow(tx *gorm.DB, obj *Object) error {
+	if err := deleteObjectParts(tx, obj); err != nil {
+		return err
+	}
+	if err := deleteObjectTags(tx, obj); err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(obj).Error
+}
+
+// commitObject runs commitObject in a transaction of its own.
+func (b *Backend) commitObject(obj *Object) (string, error) {
+	var oldPath string
+	err := b.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		oldPath, err = commitObject(tx, obj)
+		return err
+	})
+	return oldPath, err
+}
+
+// putBlob streams body into the blob store and returns the blob path, its
+// size and MD5 digest. A negative length means the size is not known up front
+// (chunked transfer) and the body is read until EOF, otherwise exactly length
+// bytes are expected.
+func (b *Backend) putBlob(body io.Reader, length int64) (string, int64, []byte, error) {
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	if length >= 0 {
+		// Read one byte past length so wrapping readers observe EOF and
+		// an over-long body is detected rather than silently truncated.
+		body = io.LimitReader(body, length+1)
+	}
+	hash := md5.New()
+	path, n, err := b.Blobs.Put(io.TeeReader(body, hash))
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", n, nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeIncompleteBody}
+		}
+		return "", n, nil, err
+	}
+	if length >= 0 && n != length {
+		b.removeBlob(path)
+		return "", n, nil, s3error.S3Error{OriginError: fmt.Errorf("content length %d is not equal to actual body length %d", length, n), Code: s3error.ErrorCodeIncompleteBody}
+	}
+	return path, n, hash.Sum(nil), nil
+}
+
+func (b *Backend) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	sec, err := b.objectSection(obj, aws.ToString(input.Range), input.PartNumber)
+	if err != nil {
+		return nil, err
+	}
+	body, err := b.openSection(obj, sec)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.GetObjectOutput{
+		Body:               body,
+		ContentLength:      sec.length,
+		LastModified:       &obj.UpdatedAt,
+		ETag:               obj.etag(),
+		ContentType:        optionalString(obj.Meta.ContentType),
+		ContentEncoding:    optionalString(obj.Meta.ContentEncoding),
+		ContentDisposition: optionalString(obj.Meta.ContentDisposition),
+		ContentLanguage:    optionalString(obj.Meta.ContentLanguage),
+		CacheControl:       optionalString(obj.Meta.CacheControl),
+		Expires:            obj.Meta.Expires,
+		Metadata:           obj.Meta.metadata(),
+		VersionId:          obj.outputVersionID(),
+	}
+	if sec.contentRange != "" {
+		out.ContentRange = aws.String(sec.contentRange)
+	}
+	if input.PartNumber != 0 {
+		out.PartsCount = obj.PartsCount
+	}
+	if out.TagCount, err = b.objectTagCount(obj); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *Backend) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	sec, err := b.objectSection(obj, "", input.PartNumber)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.HeadObjectOutput{
+		ContentLength:      sec.length,
+		LastModified:       &obj.UpdatedAt,
+		ETag:               obj.etag(),
+		ContentType:        optionalString(obj.Meta.ContentType),
+		ContentEncoding:    optionalString(obj.Meta.ContentEncoding),
+		ContentDisposition: optionalString(obj.Meta.ContentDisposition),
+		ContentLanguage:    optionalString(obj.Meta.ContentLanguage),
+		CacheControl:       optionalString(obj.Meta.CacheControl),
+		Expires:            obj.Meta.Expires,
+		Metadata:           obj.Meta.metadata(),
+		VersionId:          obj.outputVersionID(),
+	}
+	if input.PartNumber != 0 {
+		out.PartsCount = obj.PartsCount
+	}
+	return out, nil
+}
+
+// DeleteObject removes input.VersionId permanently when given. Otherwise an
+// unversioned bucket drops the object while a versioned one makes a delete
+// marker the current version.
+func (b *Backend) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if input.VersionId != nil {
+		return b.deleteVersion(bucket.BucketName, aws.ToString(input.Key), aws.ToString(input.VersionId))
+	}
+	if bucket.Versioning != "" {
+		marker := &Object{
+			BucketName:   bucket.BucketName,
+			KeyPrefix:    aws.ToString(input.Key),
+			DeleteMarker: true,
+		}
+		oldPath, err := b.commitObject(marker)
+		if err != nil {
+			return nil, err
+		}
+		b.removeBlob(oldPath)
+		return &s3.DeleteObjectOutput{DeleteMarker: true, VersionId: aws.String(marker.versionID())}, nil
+	}
+	obj, err := b.findObject(bucket.BucketName, aws.ToString(input.Key))
+	if s3error.IsNoSuchKey(err) {
+		return &s3.DeleteObjectOutput{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteObjectRow(tx, obj)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.removeBlob(obj.BlobPath)
+	return &s3.DeleteObjectOutput{}, nil
+}
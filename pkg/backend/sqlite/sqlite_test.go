@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestBackend opens a backend in a directory removed after the test.
+func newTestBackend(t *testing.T) *Backend {
+	t.Helper()
+	dir := t.TempDir()
+	b, err := New(filepath.Join(dir, "test.db"), filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// Lookups of missing rows are expected, keep them out of the output.
+	b.DB = b.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	return b
+}
+
+// errorCode is the S3 error code of err, empty for nil.
+func errorCode(err error) s3error.ErrorCode {
+	if err == nil {
+		return ""
+	}
+	var s3err s3error.S3Error
+	if errors.As(err, &s3err) {
+		return s3err.Code
+	}
+	return s3error.ErrorCodeInternalError
+}
+
+func createBucket(t *testing.T, b *Backend, bucket string) {
+	t.Helper()
+	input := &backend.CreateBucketInput{CreateBucketInput: s3.CreateBucketInput{Bucket: aws.String(bucket)}}
+	if _, err := b.CreateBucket(input); err != nil {
+		t.Fatalf("CreateBucket %s: %v", bucket, err)
+	}
+}
+
+func putObject(t *testing.T, b *Backend, bucket, key, body string) *s3.PutObjectOutput {
+	t.Helper()
+	out, err := b.PutObject(&backend.PutObjectInput{PutObjectInput: s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		Body:          strings.NewReader(body),
+		ContentLength: int64(len(body)),
+	}})
+	if err != nil {
+		t.Fatalf("PutObject %s/%s: %v", bucket, key, err)
+	}
+	return out
+}
+
+// getObject reads versionId of key, the current version when it is empty.
+func getObject(t *testing.T, b *Backend, bucket, key, versionId string) (string, error) {
+	t.Helper()
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	out, err := b.GetObject(input)
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("reading %s/%s: %v", bucket, key, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,194 @@
+package sqlite
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"gorm.io/gorm"
+)
+
+// ObjectTag is one tag of an object version.
+type ObjectTag struct {
+	ID       uint   `gorm:"primarykey"`
+	ObjectID uint   `gorm:"column:object_id;index"`
+	Key      string `gorm:"column:tag_key"`
+	Value    string `gorm:"column:tag_value"`
+}
+
+// BucketTag is one tag of a bucket.
+type BucketTag struct {
+	ID         uint   `gorm:"primarykey"`
+	BucketName string `gorm:"column:bucket_name;index"`
+	Key        string `gorm:"column:tag_key"`
+	Value      string `gorm:"column:tag_value"`
+}
+
+// parseTagging decodes the URL query form of x-amz-tagging. Limits are
+// enforced by the caller before the request reaches the backend.
+func parseTagging(tagging *string) ([]ObjectTag, error) {
+	if tagging == nil || *tagging == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(*tagging)
+	if err != nil {
+		return nil, s3error.S3Error{OriginError: err, Code: s3error.ErrorCodeInvalidArgument}
+	}
+	tags := make([]ObjectTag, 0, len(values))
+	for key := range values {
+		tags = append(tags, ObjectTag{Key: key, Value: values.Get(key)})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return tags, nil
+}
+
+func objectTagsFrom(tagSet []s3types.Tag) []ObjectTag {
+	tags := make([]ObjectTag, len(tagSet))
+	for i := range tagSet {
+		tags[i] = ObjectTag{Key: aws.ToString(tagSet[i].Key), Value: aws.ToString(tagSet[i].Value)}
+	}
+	return tags
+}
+
+// writeObjectTags stores obj.Tags for the freshly created row of obj.
+func writeObjectTags(tx *gorm.DB, obj *Object) error {
+	if len(obj.Tags) == 0 {
+		return nil
+	}
+	for i := range obj.Tags {
+		obj.Tags[i].ID, obj.Tags[i].ObjectID = 0, obj.ID
+	}
+	return tx.Create(&obj.Tags).Error
+}
+
+func deleteObjectTags(tx *gorm.DB, obj *Object) error {
+	return tx.Where("object_id = ?", obj.ID).Delete(&ObjectTag{}).Error
+}
+
+func (b *Backend) objectTags(obj *Object) ([]ObjectTag, error) {
+	var tags []ObjectTag
+	if err := b.DB.Order("id").Find(&tags, "object_id = ?", obj.ID).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (b *Backend) objectTagCount(obj *Object) (int32, error) {
+	var count int64
+	if err := b.DB.Model(&ObjectTag{}).Where("object_id = ?", obj.ID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return int32(count), nil
+}
+
+// PutObjectTagging replaces the tag set of the current or the given version.
+func (b *Backend) PutObjectTagging(input *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	if input.Tagging != nil {
+		obj.Tags = objectTagsFrom(input.Tagging.TagSet)
+	}
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteObjectTags(tx, obj); err != nil {
+			return err
+		}
+		return writeObjectTags(tx, obj)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectTaggingOutput{VersionId: obj.outputVersionID()}, nil
+}
+
+func (b *Backend) GetObjectTagging(input *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := b.objectTags(obj)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.GetObjectTaggingOutput{TagSet: make([]s3types.Tag, len(tags)), VersionId: obj.outputVersionID()}
+	for i := range tags {
+		out.TagSet[i] = s3types.Tag{Key: aws.String(tags[i].Key), Value: aws.String(tags[i].Value)}
+	}
+	return out, nil
+}
+
+func (b *Backend) DeleteObjectTagging(input *s3.DeleteObjectTaggingInput) (*s3.DeleteObjectTaggingOutput, error) {
+	obj, err := b.findObjectVersion(aws.ToString(input.Bucket), aws.ToString(input.Key), aws.ToString(input.VersionId))
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteObjectTags(b.DB, obj); err != nil {
+		return nil, err
+	}
+	return &s3.DeleteObjectTaggingOutput{VersionId: obj.outputVersionID()}, nil
+}
+
+func (b *Backend) PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var tags []BucketTag
+	if input.Tagging != nil {
+		for _, tag := range input.Tagging.TagSet {
+			tags = append(tags, BucketTag{
+				BucketName: bucket.BucketName,
+				Key:        aws.ToString(tag.Key),
+				Value:      aws.ToString(tag.Value),
+			})
+		}
+	}
+	err = b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bucket_name = ?", bucket.BucketName).Delete(&BucketTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return tx.Create(&tags).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketTaggingOutput{}, nil
+}
+
+// GetBucketTagging answers NoSuchTagSet for a bucket without tags.
+func (b *Backend) GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var tags []BucketTag
+	if err := b.DB.Order("id").Find(&tags, "bucket_name = ?", bucket.BucketName).Error; err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchTagSet}
+	}
+	out := &s3.GetBucketTaggingOutput{TagSet: make([]s3types.Tag, len(tags))}
+	for i := range tags {
+		out.TagSet[i] = s3types.Tag{Key: aws.String(tags[i].Key), Value: aws.String(tags[i].Value)}
+	}
+	return out, nil
+}
+
+func (b *Backend) DeleteBucketTagging(input *s3.DeleteBucketTaggingInput) (*s3.DeleteBucketTaggingOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Where("bucket_name = ?", bucket.BucketName).Delete(&BucketTag{}).Error; err != nil {
+		return nil, err
+	}
+	return &s3.DeleteBucketTaggingOutput{}, nil
+}
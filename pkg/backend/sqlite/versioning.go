@@ -0,0 +1,220 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"gorm.io/gorm"
+)
+
+// nullVersionID names the version written while versioning is not enabled.
+const nullVersionID = "null"
+
+func newVersionID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// storedVersionID maps a version id from a request to the version_id column.
+func storedVersionID(versionId string) string {
+	if versionId == nullVersionID {
+		return ""
+	}
+	return versionId
+}
+
+func (b *Backend) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	var status s3types.BucketVersioningStatus
+	if input.VersioningConfiguration != nil {
+		status = input.VersioningConfiguration.Status
+	}
+	switch status {
+	case s3types.BucketVersioningStatusEnabled, s3types.BucketVersioningStatusSuspended:
+	default:
+		return nil, s3error.S3Error{
+			OriginError: fmt.Errorf("versioning status must be Enabled or Suspended, got '%s'", status),
+			Code:        s3error.ErrorCodeMalformedXML,
+		}
+	}
+	if err := b.DB.Model(bucket).Update("versioning", string(status)).Error; err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (b *Backend) GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetBucketVersioningOutput{Status: s3types.BucketVersioningStatus(bucket.Versioning)}, nil
+}
+
+// findObjectVersion returns versionId of key, or its current version when
+// versionId is empty. Reading a delete marker by id is not allowed.
+func (b *Backend) findObjectVersion(bucket, key, versionId string) (*Object, error) {
+	if versionId == "" {
+		return b.findObject(bucket, key)
+	}
+	var obj Object
+	res := b.DB.First(&obj, "bucket_name = ? AND key_prefix = ? AND version_id = ?", bucket, key, storedVersionID(versionId))
+	if err := res.Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchVersion}
+	}
+	if obj.DeleteMarker {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeMethodNotAllowed}
+	}
+	return &obj, nil
+}
+
+// deleteVersion permanently removes one version of key. When it was the
+// current one the most recent remaining version takes its place. Like S3,
+// deleting a version that does not exist succeeds.
+func (b *Backend) deleteVersion(bucket, key, versionId string) (*s3.DeleteObjectOutput, error) {
+	var obj Object
+	res := b.DB.First(&obj, "bucket_name = ? AND key_prefix = ? AND version_id = ?", bucket, key, storedVersionID(versionId))
+	if res.Error != nil {
+		if res.Error != gorm.ErrRecordNotFound {
+			return nil, res.Error
+		}
+		return &s3.DeleteObjectOutput{VersionId: aws.String(versionId)}, nil
+	}
+	err := b.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteObjectRow(tx, &obj); err != nil {
+			return err
+		}
+		if obj.Noncurrent {
+			return nil
+		}
+		var next Object
+		res := tx.Order("id desc").First(&next, "bucket_name = ? AND key_prefix = ?", bucket, key)
+		if res.Error == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		return tx.Model(&next).UpdateColumn("noncurrent", false).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.removeBlob(obj.BlobPath)
+	return &s3.DeleteObjectOutput{VersionId: aws.String(obj.versionID()), DeleteMarker: obj.DeleteMarker}, nil
+}
+
+// ListObjectVersions lists every version and delete marker by key, newest
+// first within a key. Rows are ordered by id, which follows write order
+// because replacing the null version inserts a new row. Delimiter roll-up
+// works as in ListObjects.
+func (b *Backend) ListObjectVersions(input *s3.ListObjectVersionsInput) (*backend.ListObjectVersionsOutput, error) {
+	if _, err := b.findBucket(aws.ToString(input.Bucket)); err != nil {
+		return nil, err
+	}
+	bucket := aws.ToString(input.Bucket)
+	prefix, delimiter := aws.ToString(input.Prefix), aws.ToString(input.Delimiter)
+	keyMarker, versionIdMarker := aws.ToString(input.KeyMarker), aws.ToString(input.VersionIdMarker)
+	var markerID uint
+	if keyMarker != "" && versionIdMarker != "" {
+		var marker Object
+		res := b.DB.First(&marker, "bucket_name = ? AND key_prefix = ? AND version_id = ?", bucket, keyMarker, storedVersionID(versionIdMarker))
+		if res.Error != nil && res.Error != gorm.ErrRecordNotFound {
+			return nil, res.Error
+		}
+		markerID = marker.ID
+	}
+	maxKeys := int(input.MaxKeys)
+	if maxKeys < 0 || maxKeys > maxListLimit {
+		maxKeys = maxListLimit
+	}
+	out := &backend.ListObjectVersionsOutput{ListObjectVersionsOutput: s3.ListObjectVersionsOutput{
+		Name:            input.Bucket,
+		Prefix:          input.Prefix,
+		Delimiter:       input.Delimiter,
+		KeyMarker:       input.KeyMarker,
+		VersionIdMarker: input.VersionIdMarker,
+		MaxKeys:         int32(maxKeys),
+	}}
+
+	var count int
+	var lastKey, lastVersion string
+	cursorKey, cursorID := keyMarker, markerID
+walk:
+	for {
+		var batch []Object
+		tx := withPrefix(b.DB.Where("bucket_name = ?", bucket), prefix)
+		if cursorID != 0 {
+			tx = tx.Where("key_prefix > ? OR (key_prefix = ? AND id < ?)", cursorKey, cursorKey, cursorID)
+		} else {
+			tx = tx.Where("key_prefix > ?", cursorKey)
+		}
+		if err := tx.Order("key_prefix").Order("id desc").Limit(listBatch).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			obj := &batch[i]
+			cursorKey, cursorID = obj.KeyPrefix, obj.ID
+			cp := commonPrefix(obj.KeyPrefix, prefix, delimiter)
+			if cp != "" && cp <= keyMarker {
+				cursorKey, cursorID = cp+"\xff", 0
+				continue walk
+			}
+			if count == maxKeys {
+				out.IsTruncated = maxKeys > 0
+				break walk
+			}
+			count++
+			if cp != "" {
+				lastKey, lastVersion = cp, ""
+				out.CommonPrefixes = append(out.CommonPrefixes, s3types.CommonPrefix{Prefix: aws.String(cp)})
+				cursorKey, cursorID = cp+"\xff", 0
+				continue walk
+			}
+			lastKey, lastVersion = obj.KeyPrefix, obj.versionID()
+			if obj.DeleteMarker {
+				out.Entries = append(out.Entries, backend.VersionEntry{DeleteMarker: &s3types.DeleteMarkerEntry{
+					Key:          aws.String(obj.KeyPrefix),
+					VersionId:    aws.String(obj.versionID()),
+					IsLatest:     !obj.Noncurrent,
+					LastModified: aws.Time(obj.UpdatedAt),
+				}})
+				continue
+			}
+			out.Entries = append(out.Entries, backend.VersionEntry{Version: &s3types.ObjectVersion{
+				Key:          aws.String(obj.KeyPrefix),
+				VersionId:    aws.String(obj.versionID()),
+				IsLatest:     !obj.Noncurrent,
+				LastModified: aws.Time(obj.UpdatedAt),
+				ETag:         obj.etag(),
+				Size:         obj.Size,
+				StorageClass: s3types.ObjectVersionStorageClassStandard,
+			}})
+		}
+		if len(batch) < listBatch {
+			break
+		}
+	}
+	if out.IsTruncated {
+		out.NextKeyMarker = aws.String(lastKey)
+		if lastVersion != "" {
+			out.NextVersionIdMarker = aws.String(lastVersion)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,258 @@
+package sqlite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func setVersioning(t *testing.T, b *Backend, bucket string, status s3types.BucketVersioningStatus) {
+	t.Helper()
+	_, err := b.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3types.VersioningConfiguration{Status: status},
+	})
+	if err != nil {
+		t.Fatalf("PutBucketVersioning: %v", err)
+	}
+}
+
+// listVersions lists a page as "key@version" entries in listing order, with
+// a trailing * on the latest version and a leading ! on delete markers,
+// followed by the common prefixes.
+func listVersions(t *testing.T, b *Backend, input *s3.ListObjectVersionsInput) ([]string, bool) {
+	t.Helper()
+	out, err := b.ListObjectVersions(input)
+	if err != nil {
+		t.Fatalf("ListObjectVersions: %v", err)
+	}
+	var entries []string
+	for _, e := range out.Entries {
+		entry, latest := "", false
+		if m := e.DeleteMarker; m != nil {
+			entry, latest = "!"+aws.ToString(m.Key)+"@"+aws.ToString(m.VersionId), m.IsLatest
+		} else {
+			entry, latest = aws.ToString(e.Version.Key)+"@"+aws.ToString(e.Version.VersionId), e.Version.IsLatest
+		}
+		if latest {
+			entry += "*"
+		}
+		entries = append(entries, entry)
+	}
+	for _, cp := range out.CommonPrefixes {
+		entries = append(entries, aws.ToString(cp.Prefix))
+	}
+	if out.IsTruncated {
+		input.KeyMarker, input.VersionIdMarker = out.NextKeyMarker, out.NextVersionIdMarker
+	}
+	return entries, out.IsTruncated
+}
+
+func deleteObject(t *testing.T, b *Backend, bucket, key, versionId string) *s3.DeleteObjectOutput {
+	t.Helper()
+	input := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	out, err := b.DeleteObject(input)
+	if err != nil {
+		t.Fatalf("DeleteObject %s@%s: %v", key, versionId, err)
+	}
+	return out
+}
+
+func TestNullVersion(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	all := &s3.ListObjectVersionsInput{Bucket: aws.String("bucket"), MaxKeys: 1000}
+	var v3 string
+	tests := []struct {
+		name    string
+		step    func()
+		current string
+		want    func() []string
+	}{
+		{
+			name:    "unversioned write",
+			step:    func() { putObject(t, b, "bucket", "key", "one") },
+			current: "one",
+			want:    func() []string { return []string{"key@null*"} },
+		},
+		{
+			name:    "unversioned overwrite",
+			step:    func() { putObject(t, b, "bucket", "key", "two") },
+			current: "two",
+			want:    func() []string { return []string{"key@null*"} },
+		},
+		{
+			name: "enabled keeps the null version",
+			step: func() {
+				setVersioning(t, b, "bucket", s3types.BucketVersioningStatusEnabled)
+				v3 = aws.ToString(putObject(t, b, "bucket", "key", "three").VersionId)
+			},
+			current: "three",
+			want:    func() []string { return []string{"key@" + v3 + "*", "key@null"} },
+		},
+		{
+			name: "suspended replaces the null version",
+			step: func() {
+				setVersioning(t, b, "bucket", s3types.BucketVersioningStatusSuspended)
+				putObject(t, b, "bucket", "key", "four")
+			},
+			current: "four",
+			want:    func() []string { return []string{"key@null*", "key@" + v3} },
+		},
+		{
+			name: "suspended delete replaces the null version with a marker",
+			step: func() { deleteObject(t, b, "bucket", "key", "") },
+			want: func() []string { return []string{"!key@null*", "key@" + v3} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step()
+			entries, _ := listVersions(t, b, all)
+			if want := tt.want(); strings.Join(entries, ",") != strings.Join(want, ",") {
+				t.Fatalf("versions = %v, want %v", entries, want)
+			}
+			body, err := getObject(t, b, "bucket", "key", "")
+			if tt.current == "" {
+				if !s3error.IsNoSuchKey(err) {
+					t.Fatalf("GetObject = %v, want NoSuchKey", err)
+				}
+				return
+			}
+			if err != nil || body != tt.current {
+				t.Fatalf("GetObject = %q, %v, want %q", body, err, tt.current)
+			}
+		})
+	}
+}
+
+func TestDeleteVersion(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	setVersioning(t, b, "bucket", s3types.BucketVersioningStatusEnabled)
+	v1 := aws.ToString(putObject(t, b, "bucket", "key", "one").VersionId)
+	v2 := aws.ToString(putObject(t, b, "bucket", "key", "two").VersionId)
+	marker := deleteObject(t, b, "bucket", "key", "")
+	if !marker.DeleteMarker {
+		t.Fatalf("DeleteObject of a versioned key did not leave a delete marker")
+	}
+	m := aws.ToString(marker.VersionId)
+	if _, err := getObject(t, b, "bucket", "key", m); errorCode(err) != s3error.ErrorCodeMethodNotAllowed {
+		t.Fatalf("GetObject of a delete marker = %v, want MethodNotAllowed", err)
+	}
+	all := &s3.ListObjectVersionsInput{Bucket: aws.String("bucket"), MaxKeys: 1000}
+	tests := []struct {
+		name         string
+		versionId    string
+		deleteMarker bool
+		current      string
+		want         []string
+	}{
+		{name: "delete marker promotes the previous version", versionId: m, deleteMarker: true, current: "two", want: []string{"key@" + v2 + "*", "key@" + v1}},
+		{name: "noncurrent version", versionId: v1, current: "two", want: []string{"key@" + v2 + "*"}},
+		{name: "missing version", versionId: v1, current: "two", want: []string{"key@" + v2 + "*"}},
+		{name: "last version", versionId: v2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := deleteObject(t, b, "bucket", "key", tt.versionId)
+			if aws.ToString(out.VersionId) != tt.versionId || out.DeleteMarker != tt.deleteMarker {
+				t.Fatalf("DeleteObject = %s marker %v, want %s marker %v", aws.ToString(out.VersionId), out.DeleteMarker, tt.versionId, tt.deleteMarker)
+			}
+			entries, _ := listVersions(t, b, all)
+			if strings.Join(entries, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("versions = %v, want %v", entries, tt.want)
+			}
+			body, err := getObject(t, b, "bucket", "key", "")
+			if tt.current == "" {
+				if !s3error.IsNoSuchKey(err) {
+					t.Fatalf("GetObject = %v, want NoSuchKey", err)
+				}
+				return
+			}
+			if err != nil || body != tt.current {
+				t.Fatalf("GetObject = %q, %v, want %q", body, err, tt.current)
+			}
+		})
+	}
+}
+
+func TestListObjectVersionsPages(t *testing.T) {
+	b := newTestBackend(t)
+	createBucket(t, b, "bucket")
+	putObject(t, b, "bucket", "c", "null")
+	setVersioning(t, b, "bucket", s3types.BucketVersioningStatusEnabled)
+	var a []string
+	for _, body := range []string{"a1", "a2", "a3"} {
+		a = append([]string{aws.ToString(putObject(t, b, "bucket", "a", body).VersionId)}, a...)
+	}
+	b1 := aws.ToString(putObject(t, b, "bucket", "b", "b1").VersionId)
+	bm := aws.ToString(deleteObject(t, b, "bucket", "b", "").VersionId)
+	putObject(t, b, "bucket", "d/1", "d")
+	putObject(t, b, "bucket", "d/2", "d")
+	tests := []struct {
+		name      string
+		delimiter string
+		maxKeys   int32
+		pages     []string
+	}{
+		{
+			name:    "one page",
+			maxKeys: 1000,
+			pages:   []string{"a@" + a[0] + "*,a@" + a[1] + ",a@" + a[2] + ",!b@" + bm + "*,b@" + b1 + ",c@null*,d/1@" + listedVersion(t, b, "d/1") + "*,d/2@" + listedVersion(t, b, "d/2") + "*"},
+		},
+		{
+			name:    "keys split between pages",
+			maxKeys: 2,
+			pages: []string{
+				"a@" + a[0] + "*,a@" + a[1],
+				"a@" + a[2] + ",!b@" + bm + "*",
+				"b@" + b1 + ",c@null*",
+				"d/1@" + listedVersion(t, b, "d/1") + "*,d/2@" + listedVersion(t, b, "d/2") + "*",
+			},
+		},
+		{
+			name:      "common prefix",
+			delimiter: "/",
+			maxKeys:   3,
+			pages: []string{
+				"a@" + a[0] + "*,a@" + a[1] + ",a@" + a[2],
+				"!b@" + bm + "*,b@" + b1 + ",c@null*",
+				"d/",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &s3.ListObjectVersionsInput{Bucket: aws.String("bucket"), Delimiter: aws.String(tt.delimiter), MaxKeys: tt.maxKeys}
+			var pages []string
+			for {
+				entries, truncated := listVersions(t, b, input)
+				pages = append(pages, strings.Join(entries, ","))
+				if !truncated || len(pages) > len(tt.pages) {
+					break
+				}
+			}
+			if strings.Join(pages, "|") != strings.Join(tt.pages, "|") {
+				t.Fatalf("pages = %q, want %q", pages, tt.pages)
+			}
+		})
+	}
+}
+
+// listedVersion is the version id of the only version of key.
+func listedVersion(t *testing.T, b *Backend, key string) string {
+	t.Helper()
+	out, err := b.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String("bucket"), Prefix: aws.String(key), MaxKeys: 1000})
+	if err != nil || len(out.Entries) != 1 || out.Entries[0].Version == nil {
+		t.Fatalf("ListObjectVersions of %s = %v, want one version", key, err)
+	}
+	return aws.ToString(out.Entries[0].Version.VersionId)
+}
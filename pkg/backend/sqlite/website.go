@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func (b *Backend) PutBucketWebsite(input *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	config := input.WebsiteConfiguration
+	if config == nil {
+		config = &s3types.WebsiteConfiguration{}
+	}
+	doc, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("website", string(doc)).Error; err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketWebsiteOutput{}, nil
+}
+
+// GetBucketWebsite answers NoSuchWebsiteConfiguration for a bucket that is
+// not a website.
+func (b *Backend) GetBucketWebsite(input *s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if bucket.Website == "" {
+		return nil, s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeNoSuchWebsiteConfiguration}
+	}
+	var config s3types.WebsiteConfiguration
+	if err := json.Unmarshal([]byte(bucket.Website), &config); err != nil {
+		return nil, err
+	}
+	return &s3.GetBucketWebsiteOutput{
+		ErrorDocument:         config.ErrorDocument,
+		IndexDocument:         config.IndexDocument,
+		RedirectAllRequestsTo: config.RedirectAllRequestsTo,
+		RoutingRules:          config.RoutingRules,
+	}, nil
+}
+
+func (b *Backend) DeleteBucketWebsite(input *s3.DeleteBucketWebsiteInput) (*s3.DeleteBucketWebsiteOutput, error) {
+	bucket, err := b.findBucket(aws.ToString(input.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.DB.Model(bucket).Update("website", "").Error; err != nil {
+		return nil, err
+	}
+	return &s3.DeleteBucketWebsiteOutput{}, nil
+}
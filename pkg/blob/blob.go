@@ -0,0 +1,135 @@
+// Package blob keeps object payloads as plain files under a content directory,
+// so metadata stores only need to remember a relative blob path.
+package blob
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const tmpDir = ".tmp"
+
+type Store struct {
+	root string
+}
+
+// NewStore creates the content directory at root if it does not exist yet.
+func NewStore(root string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0755); err != nil {
+		return nil, err
+	}
+	return &Store{root: root}, nil
+}
+
+// Put streams r into a new blob and returns its path relative to the store
+// root together with the number of bytes written. The blob only becomes
+// visible once it has been completely written.
+func (s *Store) Put(r io.Reader) (path string, n int64, err error) {
+	tmp, err := ioutil.TempFile(filepath.Join(s.root, tmpDir), "blob-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if n, err = io.Copy(tmp, r); err != nil {
+		return "", n, err
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", n, err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", n, err
+	}
+	id, err := newID()
+	if err != nil {
+		return "", n, err
+	}
+	path = filepath.Join(id[:2], id)
+	if err = os.MkdirAll(filepath.Join(s.root, id[:2]), 0755); err != nil {
+		return "", n, err
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(s.root, path)); err != nil {
+		return "", n, err
+	}
+	return path, n, nil
+}
+
+// Open opens the blob at path for reading.
+func (s *Store) Open(path string) (*os.File, error) {
+	return os.Open(filepath.Join(s.root, path))
+}
+
+// Remove deletes the blob at path, a missing blob is not an error.
+func (s *Store) Remove(path string) error {
+	if path == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(s.root, path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// OpenConcat returns a reader over the blobs at paths laid end to end. Blobs
+// are opened one at a time so a long list does not hold many descriptors.
+func (s *Store) OpenConcat(paths []string) io.ReadCloser {
+	return &concatReader{store: s, paths: paths}
+}
+
+type concatReader struct {
+	store *Store
+	paths []string
+	cur   *os.File
+}
+
+func (r *concatReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := r.store.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.paths = f, r.paths[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *concatReader) Close() error {
+	r.paths = nil
+	if r.cur != nil {
+		err := r.cur.Close()
+		r.cur = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+// Package lifecycle applies bucket lifecycle rules in the background. It
+// works through the backend.Backend calls the gateway serves, so every
+// storage driver gets expiration without implementing it.
+package lifecycle
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	day = 24 * time.Hour
+	// pageSize is the MaxKeys of the listings a scan walks.
+	pageSize = 1000
+)
+
+// expiresAt adds days to t and rounds up to the next midnight UTC, which is
+// how S3 schedules lifecycle actions.
+func expiresAt(t time.Time, days int32) time.Time {
+	t = t.UTC().Add(time.Duration(days) * day)
+	if midnight := t.Truncate(day); midnight.Before(t) {
+		return midnight.Add(day)
+	}
+	return t
+}
+
+// rule is an enabled lifecycle rule with its filter flattened.
+type rule struct {
+	s3types.LifecycleRule
+	prefix string
+	tags   map[string]string
+}
+
+func newRule(r s3types.LifecycleRule) *rule {
+	out := &rule{LifecycleRule: r, prefix: aws.ToString(r.Prefix)}
+	addTags := func(tags ...s3types.Tag) {
+		out.tags = make(map[string]string, len(tags))
+		for _, tag := range tags {
+			out.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	switch filter := r.Filter.(type) {
+	case *s3types.LifecycleRuleFilterMemberPrefix:
+		out.prefix = filter.Value
+	case *s3types.LifecycleRuleFilterMemberTag:
+		addTags(filter.Value)
+	case *s3types.LifecycleRuleFilterMemberAnd:
+		out.prefix = aws.ToString(filter.Value.Prefix)
+		addTags(filter.Value.Tags...)
+	}
+	return out
+}
+
+// match reports whether an object with key and tags is in scope of r.
+func (r *rule) match(key string, tags map[string]string) bool {
+	if !strings.HasPrefix(key, r.prefix) {
+		return false
+	}
+	for k, v := range r.tags {
+		if value, ok := tags[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// version is one entry of a key in ListObjectVersions.
+type version struct {
+	versionId    string
+	latest       bool
+	deleteMarker bool
+	lastModified time.Time
+}
+
+// Worker expires current objects and noncurrent versions and aborts
+// abandoned multipart uploads every Interval.
+type Worker struct {
+	Backend  backend.Backend
+	Interval time.Duration
+}
+
+func NewWorker(be backend.Backend, interval time.Duration) *Worker {
+	return &Worker{Backend: be, Interval: interval}
+}
+
+// Run scans all buckets right away and then every Interval until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Scan(time.Now()); err != nil {
+			logrus.WithError(err).Warnln("lifecycle scan failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan applies the rules of every bucket as of now. A failing bucket is
+// logged and retried on the next scan.
+func (w *Worker) Scan(now time.Time) error {
+	buckets, err := w.Backend.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets.Buckets {
+		name := aws.ToString(bucket.Name)
+		if err := w.scanBucket(name, now); err != nil {
+			logrus.WithError(err).WithField("bucket", name).Warnln("lifecycle scan of bucket failed")
+		}
+	}
+	return nil
+}
+
+func (w *Worker) scanBucket(bucket string, now time.Time) error {
+	config, err := w.Backend.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if s3error.CodeOf(err) == s3error.ErrorCodeNoSuchLifecycleConfiguration {
+			return nil
+		}
+		return err
+	}
+	var rules []*rule
+	for _, r := range config.Rules {
+		if r.Status == s3types.ExpirationStatusEnabled {
+			rules = append(rules, newRule(r))
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	if err := w.expireVersions(bucket, rules, now); err != nil {
+		return err
+	}
+	return w.abortUploads(bucket, rules, now)
+}
+
+// expireVersions walks every version of the bucket key by key, newest
+// first. A key is handled once all of its versions have been listed, so
+// the version a page ends with is never deleted before the next page is
+// requested from it.
+func (w *Worker) expireVersions(bucket string, rules []*rule, now time.Time) error {
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucket), MaxKeys: pageSize}
+	var key string
+	var versions []version
+	for {
+		out, err := w.Backend.ListObjectVersions(input)
+		if err != nil {
+			return err
+		}
+		for _, e := range out.Entries {
+			var k string
+			var v version
+			if m := e.DeleteMarker; m != nil {
+				k, v = aws.ToString(m.Key), version{aws.ToString(m.VersionId), m.IsLatest, true, aws.ToTime(m.LastModified)}
+			} else {
+				k, v = aws.ToString(e.Version.Key), version{aws.ToString(e.Version.VersionId), e.Version.IsLatest, false, aws.ToTime(e.Version.LastModified)}
+			}
+			if k != key && len(versions) > 0 {
+				if err := w.expireKey(bucket, key, versions, rules, now); err != nil {
+					return err
+				}
+				versions = versions[:0]
+			}
+			key = k
+			versions = append(versions, v)
+		}
+		if !out.IsTruncated {
+			break
+		}
+		input.KeyMarker, input.VersionIdMarker = out.NextKeyMarker, out.NextVersionIdMarker
+	}
+	if len(versions) > 0 {
+		return w.expireKey(bucket, key, versions, rules, now)
+	}
+	return nil
+}
+
+// expireKey applies the rules to the versions of key, newest first. A
+// noncurrent version became noncurrent when the next newer one was written.
+// NewerNoncurrentVersions counts the noncurrent versions kept so far, versions
+// expired earlier in the pass and delete markers do not count.
+func (w *Worker) expireKey(bucket, key string, versions []version, rules []*rule, now time.Time) error {
+	remaining := len(versions)
+	var retained int32
+	for i, v := range versions {
+		kept := true
+		var tags map[string]string
+		if !v.deleteMarker {
+			var err error
+			if tags, err = w.objectTags(bucket, key, v.versionId, rules); err != nil {
+				return err
+			}
+		}
+		for _, r := range rules {
+			// Delete markers carry no tags, tag filtered rules skip them.
+			if !r.match(key, tags) {
+				continue
+			}
+			if v.latest {
+				if v.deleteMarker || !currentExpired(r, v, now) {
+					continue
+				}
+				// Expiring the current version leaves a delete marker in
+				// versioned buckets, as a plain DeleteObject does.
+				if err := w.deleteObject(bucket, key, ""); err != nil {
+					return err
+				}
+				break
+			}
+			exp := r.NoncurrentVersionExpiration
+			if exp == nil || retained < exp.NewerNoncurrentVersions {
+				continue
+			}
+			if now.Before(expiresAt(versions[i-1].lastModified, exp.NoncurrentDays)) {
+				continue
+			}
+			if err := w.deleteObject(bucket, key, v.versionId); err != nil {
+				return err
+			}
+			remaining--
+			kept = false
+			break
+		}
+		if kept && !v.latest && !v.deleteMarker {
+			retained++
+		}
+	}
+	// A delete marker left without older versions is an expired object
+	// delete marker.
+	if current := versions[0]; current.latest && current.deleteMarker && remaining == 1 {
+		for _, r := range rules {
+			if r.Expiration != nil && r.Expiration.ExpiredObjectDeleteMarker && len(r.tags) == 0 && r.match(key, nil) {
+				return w.deleteObject(bucket, key, current.versionId)
+			}
+		}
+	}
+	return nil
+}
+
+func currentExpired(r *rule, v version, now time.Time) bool {
+	exp := r.Expiration
+	switch {
+	case exp == nil:
+		return false
+	case exp.Date != nil:
+		return !now.Before(*exp.Date)
+	case exp.Days > 0:
+		return !now.Before(expiresAt(v.lastModified, exp.Days))
+	}
+	return false
+}
+
+// objectTags loads the tags of a version only when some rule filters on tags.
+func (w *Worker) objectTags(bucket, key, versionId string, rules []*rule) (map[string]string, error) {
+	needed := false
+	for _, r := range rules {
+		needed = needed || len(r.tags) > 0
+	}
+	if !needed {
+		return nil, nil
+	}
+	out, err := w.Backend.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(out.TagSet))
+	for _, tag := range out.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+func (w *Worker) deleteObject(bucket, key, versionId string) error {
+	input := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	if _, err := w.Backend.DeleteObject(input); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{"bucket": bucket, "key": key, "versionId": versionId}).Infoln("lifecycle expired object")
+	return nil
+}
+
+// abortUploads collects the expired uploads first, aborting them while
+// listing would remove the marker of the next page.
+func (w *Worker) abortUploads(bucket string, rules []*rule, now time.Time) error {
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(bucket), MaxUploads: pageSize}
+	var expired []s3types.MultipartUpload
+	for {
+		out, err := w.Backend.ListMultipartUploads(input)
+		if err != nil {
+			return err
+		}
+		for _, upload := range out.Uploads {
+			for _, r := range rules {
+				abort := r.AbortIncompleteMultipartUpload
+				if abort == nil || len(r.tags) > 0 || !r.match(aws.ToString(upload.Key), nil) {
+					continue
+				}
+				if !now.Before(expiresAt(aws.ToTime(upload.Initiated), abort.DaysAfterInitiation)) {
+					expired = append(expired, upload)
+					break
+				}
+			}
+		}
+		if !out.IsTruncated {
+			break
+		}
+		input.KeyMarker, input.UploadIdMarker = out.NextKeyMarker, out.NextUploadIdMarker
+	}
+	for _, upload := range expired {
+		_, err := w.Backend.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil && s3error.CodeOf(err) != s3error.ErrorCodeNoSuchUpload {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{"bucket": bucket, "key": aws.ToString(upload.Key), "uploadId": aws.ToString(upload.UploadId)}).Infoln("lifecycle aborted upload")
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package lifecycle
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dashjay/overlay_oss/pkg/backend"
+	"github.com/dashjay/overlay_oss/pkg/backend/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testBucket writes the versions of "key" in a versioned bucket, oldest
+// first: "marker" deletes the key and anything else is put as its body. It
+// returns the labels of the version ids.
+func testBucket(t *testing.T, be backend.Backend, writes []string) map[string]string {
+	t.Helper()
+	bucket := aws.String("bucket")
+	if _, err := be.CreateBucket(&backend.CreateBucketInput{CreateBucketInput: s3.CreateBucketInput{Bucket: bucket}}); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	_, err := be.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  bucket,
+		VersioningConfiguration: &s3types.VersioningConfiguration{Status: s3types.BucketVersioningStatusEnabled},
+	})
+	if err != nil {
+		t.Fatalf("PutBucketVersioning: %v", err)
+	}
+	labels := make(map[string]string, len(writes))
+	for _, w := range writes {
+		if w == "marker" {
+			out, err := be.DeleteObject(&s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("key")})
+			if err != nil {
+				t.Fatalf("DeleteObject: %v", err)
+			}
+			labels[aws.ToString(out.VersionId)] = w
+			continue
+		}
+		out, err := be.PutObject(&backend.PutObjectInput{PutObjectInput: s3.PutObjectInput{
+			Bucket:        bucket,
+			Key:           aws.String("key"),
+			Body:          strings.NewReader(w),
+			ContentLength: int64(len(w)),
+		}})
+		if err != nil {
+			t.Fatalf("PutObject: %v", err)
+		}
+		labels[aws.ToString(out.VersionId)] = w
+	}
+	return labels
+}
+
+// remaining lists the labels of the versions of "key", newest first.
+func remaining(t *testing.T, be backend.Backend, labels map[string]string) []string {
+	t.Helper()
+	out, err := be.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String("bucket"), MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjectVersions: %v", err)
+	}
+	var left []string
+	for _, e := range out.Entries {
+		var label string
+		var ok bool
+		if m := e.DeleteMarker; m != nil {
+			label, ok = labels[aws.ToString(m.VersionId)]
+		} else {
+			label, ok = labels[aws.ToString(e.Version.VersionId)]
+		}
+		if !ok {
+			// A delete marker left by expiring the current version.
+			label = "expired"
+		}
+		left = append(left, label)
+	}
+	return left
+}
+
+func TestScan(t *testing.T) {
+	enabled := func(r s3types.LifecycleRule) []s3types.LifecycleRule {
+		r.Status = s3types.ExpirationStatusEnabled
+		r.Filter = &s3types.LifecycleRuleFilterMemberPrefix{Value: ""}
+		return []s3types.LifecycleRule{r}
+	}
+	tests := []struct {
+		name   string
+		writes []string
+		rules  []s3types.LifecycleRule
+		want   []string
+	}{
+		{
+			name:   "noncurrent days",
+			writes: []string{"v1", "v2", "v3"},
+			rules:  enabled(s3types.LifecycleRule{NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{NoncurrentDays: 1}}),
+			want:   []string{"v3"},
+		},
+		{
+			name:   "newer noncurrent versions",
+			writes: []string{"v1", "v2", "v3", "v4", "v5"},
+			rules: enabled(s3types.LifecycleRule{NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{
+				NoncurrentDays:          1,
+				NewerNoncurrentVersions: 2,
+			}}),
+			want: []string{"v5", "v4", "v3"},
+		},
+		{
+			name:   "delete markers are not newer noncurrent versions",
+			writes: []string{"v2", "v3", "v4", "marker", "v5"},
+			rules: enabled(s3types.LifecycleRule{NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{
+				NoncurrentDays:          1,
+				NewerNoncurrentVersions: 2,
+			}}),
+			want: []string{"v5", "marker", "v4", "v3"},
+		},
+		{
+			name:   "noncurrent versions not yet due",
+			writes: []string{"v1", "v2"},
+			rules:  enabled(s3types.LifecycleRule{NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{NoncurrentDays: 30}}),
+			want:   []string{"v2", "v1"},
+		},
+		{
+			name:   "current version expires into a delete marker",
+			writes: []string{"v1"},
+			rules:  enabled(s3types.LifecycleRule{Expiration: &s3types.LifecycleExpiration{Days: 1}}),
+			want:   []string{"expired", "v1"},
+		},
+		{
+			name:   "expired object delete marker",
+			writes: []string{"v1", "marker"},
+			rules: enabled(s3types.LifecycleRule{
+				Expiration:                  &s3types.LifecycleExpiration{ExpiredObjectDeleteMarker: true},
+				NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{NoncurrentDays: 1},
+			}),
+			want: nil,
+		},
+		{
+			name:   "delete marker with older versions",
+			writes: []string{"v1", "marker"},
+			rules:  enabled(s3types.LifecycleRule{Expiration: &s3types.LifecycleExpiration{ExpiredObjectDeleteMarker: true}}),
+			want:   []string{"marker", "v1"},
+		},
+		{
+			name:   "other prefix",
+			writes: []string{"v1", "v2"},
+			rules: []s3types.LifecycleRule{{
+				Status:                      s3types.ExpirationStatusEnabled,
+				Filter:                      &s3types.LifecycleRuleFilterMemberPrefix{Value: "other/"},
+				NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{NoncurrentDays: 1},
+			}},
+			want: []string{"v2", "v1"},
+		},
+		{
+			name:   "disabled rule",
+			writes: []string{"v1", "v2"},
+			rules: []s3types.LifecycleRule{{
+				Status:                      s3types.ExpirationStatusDisabled,
+				Filter:                      &s3types.LifecycleRuleFilterMemberPrefix{Value: ""},
+				NoncurrentVersionExpiration: &s3types.NoncurrentVersionExpiration{NoncurrentDays: 1},
+			}},
+			want: []string{"v2", "v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			be, err := sqlite.New(filepath.Join(dir, "test.db"), filepath.Join(dir, "data"))
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			be.DB = be.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+			labels := testBucket(t, be, tt.writes)
+			_, err = be.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 aws.String("bucket"),
+				LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: tt.rules},
+			})
+			if err != nil {
+				t.Fatalf("PutBucketLifecycleConfiguration: %v", err)
+			}
+			if err := NewWorker(be, time.Hour).Scan(time.Now().Add(3 * day)); err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if left := remaining(t, be, labels); strings.Join(left, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("versions left = %v, want %v", left, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		days int32
+		want time.Time
+	}{
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 1, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), 1, time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 1, 23, 0, 0, 0, time.FixedZone("", -2*3600)), 0, time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := expiresAt(tt.t, tt.days); !got.Equal(tt.want) {
+			t.Errorf("expiresAt(%v, %d) = %v, want %v", tt.t, tt.days, got, tt.want)
+		}
+	}
+}
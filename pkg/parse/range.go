@@ -0,0 +1,103 @@
+package parse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// ByteRange is the satisfiable part of a Range header, Length > 0.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange renders the Content-Range response header for an object of size bytes.
+func (b *ByteRange) ContentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(b.Start, 10) + "-" + strconv.FormatInt(b.Start+b.Length-1, 10) +
+		"/" + strconv.FormatInt(size, 10)
+}
+
+// Range resolves a "bytes=" Range header against an object of size bytes.
+// Like S3 it only honors a single range: a missing, malformed or multi-range
+// header yields nil and the whole object is served, while a well-formed but
+// unsatisfiable range yields InvalidRange.
+func Range(header string, size int64) (*ByteRange, error) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") {
+		return nil, nil
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return nil, nil
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+	invalid := s3error.S3Error{OriginError: nil, Code: s3error.ErrorCodeInvalidRange}
+
+	if first == "" {
+		// Suffix range, the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, invalid
+		}
+		if n > size {
+			n = size
+		}
+		return &ByteRange{Start: size - n, Length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return nil, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return nil, invalid
+	}
+	return &ByteRange{Start: start, Length: end - start + 1}, nil
+}
+
+// CopySourceRange resolves x-amz-copy-source-range against a source of size
+// bytes. Unlike Range it is strict: only "bytes=first-last" within the
+// source is accepted, anything else is InvalidArgument.
+func CopySourceRange(header string, size int64) (*ByteRange, error) {
+	invalid := s3error.S3Error{
+		OriginError: fmt.Errorf("range specified is not valid for source object of size: %d", size),
+		Code:        s3error.ErrorCodeInvalidArgument,
+	}
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") {
+		return nil, invalid
+	}
+	spec = strings.TrimPrefix(spec, "bytes=")
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return nil, invalid
+	}
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil || start < 0 {
+		return nil, invalid
+	}
+	end, err := strconv.ParseInt(spec[dash+1:], 10, 64)
+	if err != nil || end < start || end >= size {
+		return nil, invalid
+	}
+	return &ByteRange{Start: start, Length: end - start + 1}, nil
+}
@@ -3,6 +3,7 @@ package parse
 import (
 	"github.com/dashjay/overlay_oss/pkg/types"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -33,7 +34,8 @@ const (
 	ListTypeV2        = "2"
 	StartAfter        = "start-after"
 	ContinuationToken = "continuation-token"
-	PartNumber        = "part-number"
+	EncodingType      = "encoding-type"
+	PartNumber        = "partNumber"
 	PartNumberMarker  = "part-number-marker"
 	MaxUploads        = "max-uploads"
 	MaxKeys           = "max-keys"
@@ -42,6 +44,8 @@ const (
 	UploadId          = "uploadId"
 	UploadIdMarker    = "upload-id-marker"
 	Delete            = "delete"
+	Cors              = "cors"
+	Website           = "website"
 
 	// Did not implement
 	Acl        = "acl"
@@ -51,6 +55,31 @@ const (
 	Versioning = "versioning"
 )
 
+// Domains are the base domains of virtual-hosted-style requests: a request
+// for bucket.domain names its bucket in the Host header and its key in the
+// whole path. Requests to any other host are path-style. Domains is set once
+// before serving.
+var Domains []string
+
+// HostBucket returns the bucket named by the subdomain of host, if host is
+// below one of domains.
+func HostBucket(host string, domains []string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if domain == "" {
+			continue
+		}
+		if bucket := strings.TrimSuffix(host, "."+domain); bucket != host && bucket != "" {
+			return bucket, true
+		}
+	}
+	return "", false
+}
+
 func S3Query(r *http.Request) (q types.S3Query) {
 	// path2BucketAndObject Copy from https://github.com/minio/minio/blob/master/cmd/handler-utils.go
 	path2BucketAndObject := func(path string) (bucket, object string) {
@@ -68,6 +97,10 @@ func S3Query(r *http.Request) (q types.S3Query) {
 		return bucket, object
 	}
 	bucket, object := path2BucketAndObject(r.URL.Path)
+	if b, ok := HostBucket(r.Host, Domains); ok {
+		bucket, object = b, strings.TrimPrefix(r.URL.Path, "/")
+		q.VirtualHost = true
+	}
 	query := r.URL.Query()
 	parseIntFromQuery := func(key string, into *int64, defalt int64) {
 		v := query.Get(key)
@@ -104,12 +137,10 @@ func S3Query(r *http.Request) (q types.S3Query) {
 
 	if query.Get(ListType) == ListTypeV2 {
 		q.ListQuery.Version = 2
-
-		q.ListQuery.Marker = query.Get(StartAfter)
-		if token := query.Get(ContinuationToken); token != "" {
-			q.ListQuery.Marker = token
-		}
+		q.ListQuery.Marker = ""
+		q.ListQuery.StartAfter, q.ListQuery.ContinuationToken = query.Get(StartAfter), query.Get(ContinuationToken)
 	}
+	q.ListQuery.EncodingType = query.Get(EncodingType)
 	q.ListQuery.MaxKeys = 1000
 	parseIntFromQuery(MaxKeys, &q.ListQuery.MaxKeys, 1000)
 
@@ -119,16 +150,137 @@ func S3Query(r *http.Request) (q types.S3Query) {
 		query.Get(UploadId), query.Get(KeyMarker), query.Get(UploadIdMarker)
 
 	parseIntFromQuery(MaxUploads, &q.MpQuery.MaxUploads, 1000)
-	parseIntFromQuery(PartNumberMarker, &q.MpQuery.Marker, 1000)
+	parseIntFromQuery(PartNumberMarker, &q.MpQuery.Marker, 0)
 	parseIntFromQuery(PartNumber, &q.MpQuery.PartNumber, 0)
 
-	parseIntFromQuery(MaxParts, &q.MpQuery.MaxParts, 0)
+	parseIntFromQuery(MaxParts, &q.MpQuery.MaxParts, 1000)
 
 	// Check for batch delete.
 	q.BatchDelQuery = inQuery(Delete)
 
 	q.DstObj.Bucket = bucket
-	if anyInQuery(Acl, Lifecycle, Policy, Tagging, Versioning) {
+	// Browsers send preflights without credentials, whatever the query.
+	if bucket != "" && r.Method == http.MethodOptions {
+		q.DstObj.Key = object
+		q.Type = types.PreflightObject
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Cors) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketCors
+		case http.MethodPut:
+			q.Type = types.PutBucketCors
+		case http.MethodDelete:
+			q.Type = types.DeleteBucketCors
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Website) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketWebsite
+		case http.MethodPut:
+			q.Type = types.PutBucketWebsite
+		case http.MethodDelete:
+			q.Type = types.DeleteBucketWebsite
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Versioning) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketVersioning
+		case http.MethodPut:
+			q.Type = types.PutBucketVersioning
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Tagging) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketTagging
+		case http.MethodPut:
+			q.Type = types.PutBucketTagging
+		case http.MethodDelete:
+			q.Type = types.DeleteBucketTagging
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Lifecycle) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketLifecycle
+		case http.MethodPut:
+			q.Type = types.PutBucketLifecycle
+		case http.MethodDelete:
+			q.Type = types.DeleteBucketLifecycle
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Policy) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketPolicy
+		case http.MethodPut:
+			q.Type = types.PutBucketPolicy
+		case http.MethodDelete:
+			q.Type = types.DeleteBucketPolicy
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if bucket != "" && object == "" && inQuery(Acl) {
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetBucketAcl
+		case http.MethodPut:
+			q.Type = types.PutBucketAcl
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if object != "" && inQuery(Acl) {
+		q.DstObj.Key = object
+		q.DstObj.VersionId = query.Get("versionId")
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetObjectAcl
+		case http.MethodPut:
+			q.Type = types.PutObjectAcl
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if object != "" && inQuery(Tagging) {
+		q.DstObj.Key = object
+		q.DstObj.VersionId = query.Get("versionId")
+		switch r.Method {
+		case http.MethodGet:
+			q.Type = types.GetObjectTagging
+		case http.MethodPut:
+			q.Type = types.PutObjectTagging
+		case http.MethodDelete:
+			q.Type = types.DeleteObjectTagging
+		default:
+			q.Type = types.NotImplementOperation
+		}
+		return
+	}
+	if anyInQuery(Acl, Cors, Lifecycle, Policy, Tagging, Versioning, Website) {
 		q.Type = types.NotImplementOperation
 		return
 	}
@@ -164,7 +316,8 @@ func S3Query(r *http.Request) (q types.S3Query) {
 				q.Type = types.DeleteObjects
 				return
 			}
-			q.Type = types.NotImplementOperation
+			// Browser form uploads name their key in the form body.
+			q.Type = types.PostObject
 			return
 		default:
 			q.Type = types.NotImplementOperation
@@ -191,8 +344,12 @@ func S3Query(r *http.Request) (q types.S3Query) {
 				log.WithError(err).Warning("QueryUnescape failed")
 				src = v
 			}
+			if i := strings.Index(src, "?versionId="); i >= 0 {
+				q.SrcObj.VersionId = src[i+len("?versionId="):]
+				src = src[:i]
+			}
 			q.SrcObj.Bucket, q.SrcObj.Key = path2BucketAndObject(src)
-			if object == "" {
+			if object == "" || !q.HasCopy() {
 				q.Type = types.ErrorOperation
 				return
 			}
@@ -201,6 +358,10 @@ func S3Query(r *http.Request) (q types.S3Query) {
 			q.Type = types.MultipartUpload
 			return
 		}
+		if q.HasCopy() {
+			q.Type = types.CopyObject
+			return
+		}
 		q.Type = types.PutObject
 		return
 	case http.MethodDelete:
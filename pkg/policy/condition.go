@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// operator evaluates one condition key. want holds the values from the
+// policy, have those of the request, which is empty when the key is absent.
+type operator struct {
+	eval func(want, have []string) bool
+	// ip marks operators whose values must be addresses or CIDR blocks.
+	ip bool
+}
+
+var operators = map[string]operator{
+	"StringEquals":              {eval: anyValue(func(w, h string) bool { return w == h })},
+	"StringNotEquals":           {eval: noValue(func(w, h string) bool { return w == h })},
+	"StringEqualsIgnoreCase":    {eval: anyValue(strings.EqualFold)},
+	"StringNotEqualsIgnoreCase": {eval: noValue(strings.EqualFold)},
+	"StringLike":                {eval: anyValue(match)},
+	"StringNotLike":             {eval: noValue(match)},
+	"NumericEquals":             {eval: anyValue(numeric(func(w, h float64) bool { return h == w }))},
+	"NumericNotEquals":          {eval: noValue(numeric(func(w, h float64) bool { return h == w }))},
+	"NumericLessThan":           {eval: anyValue(numeric(func(w, h float64) bool { return h < w }))},
+	"NumericLessThanEquals":     {eval: anyValue(numeric(func(w, h float64) bool { return h <= w }))},
+	"NumericGreaterThan":        {eval: anyValue(numeric(func(w, h float64) bool { return h > w }))},
+	"NumericGreaterThanEquals":  {eval: anyValue(numeric(func(w, h float64) bool { return h >= w }))},
+	"Bool":                      {eval: anyValue(strings.EqualFold)},
+	"IpAddress":                 {eval: anyValue(inNetwork), ip: true},
+	"NotIpAddress":              {eval: noValue(inNetwork), ip: true},
+	"Null":                      {eval: null},
+}
+
+// anyValue holds when some request value matches some policy value, an
+// absent key never matches.
+func anyValue(fn func(want, have string) bool) func(want, have []string) bool {
+	return func(want, have []string) bool {
+		for _, h := range have {
+			for _, w := range want {
+				if fn(w, h) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// noValue is the negation of anyValue, an absent key always matches.
+func noValue(fn func(want, have string) bool) func(want, have []string) bool {
+	positive := anyValue(fn)
+	return func(want, have []string) bool {
+		return !positive(want, have)
+	}
+}
+
+func numeric(fn func(want, have float64) bool) func(want, have string) bool {
+	return func(want, have string) bool {
+		w, err := strconv.ParseFloat(want, 64)
+		if err != nil {
+			return false
+		}
+		h, err := strconv.ParseFloat(have, 64)
+		if err != nil {
+			return false
+		}
+		return fn(w, h)
+	}
+}
+
+// null checks for the absence ("true") or presence ("false") of a key.
+func null(want, have []string) bool {
+	for _, w := range want {
+		if strings.EqualFold(w, "true") == (len(have) == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDR accepts a CIDR block or a single address.
+func parseCIDR(v string) (*net.IPNet, error) {
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: v}
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(v)
+	return network, err
+}
+
+func inNetwork(want, have string) bool {
+	network, err := parseCIDR(want)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(have)
+	return ip != nil && network.Contains(ip)
+}
@@ -0,0 +1,301 @@
+// Package policy parses and evaluates JSON bucket policies in the IAM
+// policy language: Allow and Deny statements over principals, actions and
+// resource ARNs, optionally narrowed by conditions.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+const (
+	// ResourcePrefix starts the ARN of every bucket and object.
+	ResourcePrefix = "arn:aws:s3:::"
+	// MaxSize is the largest bucket policy S3 accepts.
+	MaxSize = 20 << 10
+
+	effectAllow = "Allow"
+	effectDeny  = "Deny"
+)
+
+// Decision is the outcome of evaluating a policy for one request.
+type Decision int
+
+const (
+	// NoMatch means no statement applies, the caller's default holds.
+	NoMatch Decision = iota
+	Allow
+	// Deny is an explicit Deny, it overrides any Allow.
+	Deny
+)
+
+// Request describes the access being evaluated.
+type Request struct {
+	// Principal is the requester's canonical id, empty for anonymous.
+	Principal string
+	// Action is an IAM action name such as s3:GetObject.
+	Action string
+	// Resource is the ARN of the bucket or object.
+	Resource string
+	// Conditions holds the condition key values of the request, keys are
+	// lower-cased.
+	Conditions map[string][]string
+}
+
+// ResourceARN is the ARN of key in bucket, or of the bucket itself when key
+// is empty.
+func ResourceARN(bucket, key string) string {
+	if key == "" {
+		return ResourcePrefix + bucket
+	}
+	return ResourcePrefix + bucket + "/" + key
+}
+
+// stringSet is a JSON string or array of strings. Condition values may also
+// be numbers or booleans, they are kept in their string form.
+type stringSet []string
+
+func (s *stringSet) UnmarshalJSON(b []byte) error {
+	var values []interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		if err := json.Unmarshal(b, &values); err != nil {
+			return err
+		}
+	} else {
+		var value interface{}
+		if err := json.Unmarshal(b, &value); err != nil {
+			return err
+		}
+		values = []interface{}{value}
+	}
+	*s = make(stringSet, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			*s = append(*s, v)
+		case bool:
+			*s = append(*s, strconv.FormatBool(v))
+		case float64:
+			*s = append(*s, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return fmt.Errorf("expected a string, got %v", v)
+		}
+	}
+	return nil
+}
+
+// principal is either "*" or an object such as {"AWS": [...]}.
+type principal struct {
+	any bool
+	aws stringSet
+}
+
+func (p *principal) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("principal must be \"*\" or an object, got %q", s)
+		}
+		p.any = true
+		return nil
+	}
+	var m map[string]stringSet
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for kind, ids := range m {
+		if kind != "AWS" {
+			return fmt.Errorf("unsupported principal type %q", kind)
+		}
+		p.aws = ids
+	}
+	return nil
+}
+
+// match accepts "*", the canonical id itself or the root ARN of its
+// account, arn:aws:iam::<id>:root. Anonymous requesters only match "*".
+func (p *principal) match(id string) bool {
+	if p.any {
+		return true
+	}
+	for _, v := range p.aws {
+		if v == "*" || (id != "" && (v == id || v == "arn:aws:iam::"+id+":root")) {
+			return true
+		}
+	}
+	return false
+}
+
+type Statement struct {
+	Sid       string                          `json:"Sid,omitempty"`
+	Effect    string                          `json:"Effect"`
+	Principal *principal                      `json:"Principal"`
+	Action    stringSet                       `json:"Action"`
+	Resource  stringSet                       `json:"Resource"`
+	Condition map[string]map[string]stringSet `json:"Condition,omitempty"`
+}
+
+type Policy struct {
+	Version    string      `json:"Version,omitempty"`
+	ID         string      `json:"Id,omitempty"`
+	Statements []Statement `json:"Statement"`
+}
+
+func malformed(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeMalformedPolicy}
+}
+
+// Parse decodes and validates a policy document of bucket. Every resource
+// must lie within the bucket.
+func Parse(doc []byte, bucket string) (*Policy, error) {
+	if len(doc) > MaxSize {
+		return nil, malformed("policies cannot exceed %d bytes", MaxSize)
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, malformed("%v", err)
+	}
+	switch p.Version {
+	case "", "2008-10-17", "2012-10-17":
+	default:
+		return nil, malformed("invalid policy version %q", p.Version)
+	}
+	if len(p.Statements) == 0 {
+		return nil, malformed("could not parse the policy: statement is empty")
+	}
+	for i := range p.Statements {
+		if err := p.Statements[i].validate(bucket); err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+func (s *Statement) validate(bucket string) error {
+	if s.Effect != effectAllow && s.Effect != effectDeny {
+		return malformed("invalid effect %q", s.Effect)
+	}
+	if s.Principal == nil {
+		return malformed("missing required field Principal")
+	}
+	if len(s.Action) == 0 {
+		return malformed("missing required field Action")
+	}
+	for _, action := range s.Action {
+		if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+			return malformed("policy has invalid action %q", action)
+		}
+	}
+	if len(s.Resource) == 0 {
+		return malformed("missing required field Resource")
+	}
+	for _, resource := range s.Resource {
+		name := strings.TrimPrefix(resource, ResourcePrefix)
+		if name == resource || !match(strings.SplitN(name, "/", 2)[0], bucket) {
+			return malformed("policy has invalid resource %q", resource)
+		}
+	}
+	for op, conditions := range s.Condition {
+		cond, ok := operators[op]
+		if !ok {
+			return malformed("unsupported condition operator %q", op)
+		}
+		if cond.ip {
+			for _, values := range conditions {
+				for _, v := range values {
+					if _, err := parseCIDR(v); err != nil {
+						return malformed("invalid IP address %q", v)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate applies every statement to req. A Deny statement wins over any
+// Allow, when neither applies the result is NoMatch.
+func (p *Policy) Evaluate(req *Request) Decision {
+	decision := NoMatch
+	for i := range p.Statements {
+		s := &p.Statements[i]
+		if !s.applies(req) {
+			continue
+		}
+		if s.Effect == effectDeny {
+			return Deny
+		}
+		decision = Allow
+	}
+	return decision
+}
+
+func (s *Statement) applies(req *Request) bool {
+	if !s.Principal.match(req.Principal) {
+		return false
+	}
+	matched := false
+	for _, action := range s.Action {
+		// Action names are case insensitive.
+		if match(strings.ToLower(action), strings.ToLower(req.Action)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	matched = false
+	for _, resource := range s.Resource {
+		if match(resource, req.Resource) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	for op, conditions := range s.Condition {
+		for key, values := range conditions {
+			if !operators[op].eval(values, req.Conditions[strings.ToLower(key)]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// match reports whether s matches pattern, where * matches any run of
+// characters and ? any single character. On a mismatch it backtracks only
+// to the last *, which keeps matching linear in len(pattern)*len(s).
+func match(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case star >= 0:
+			// Let the last * swallow one more character.
+			next++
+			p, i = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,237 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything/at/all", true},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"abc", "ab", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"arn:aws:s3:::bucket/*", "arn:aws:s3:::bucket/dir/key", true},
+		{"arn:aws:s3:::bucket/*", "arn:aws:s3:::bucket", false},
+		{"arn:aws:s3:::bucket/*.jpg", "arn:aws:s3:::bucket/a.jpg/b.png", false},
+		{"arn:aws:s3:::bucket/*.jpg", "arn:aws:s3:::bucket/a.png/b.jpg", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXcYb", false},
+		{"*a*", "bab", true},
+		{"**", "x", true},
+		{"a*", "a*", true},
+		{"*x", "x*", false},
+		{"?*", "", false},
+		// Without backtracking limited to the last *, these take
+		// exponential time.
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100), false},
+		{strings.Repeat("*a", 30), strings.Repeat("a", 100), true},
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+const testPolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Sid": "PublicRead",
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": "s3:GetObject",
+			"Resource": "arn:aws:s3:::bucket/public/*"
+		},
+		{
+			"Sid": "BobWrites",
+			"Effect": "Allow",
+			"Principal": {"AWS": ["arn:aws:iam::bob:root"]},
+			"Action": ["s3:PutObject", "s3:DeleteObject*"],
+			"Resource": "arn:aws:s3:::bucket/*"
+		},
+		{
+			"Sid": "NoSecrets",
+			"Effect": "Deny",
+			"Principal": "*",
+			"Action": "s3:*",
+			"Resource": "arn:aws:s3:::bucket/public/secret/*"
+		},
+		{
+			"Sid": "OfficeLists",
+			"Effect": "Allow",
+			"Principal": {"AWS": "*"},
+			"Action": "S3:ListBucket",
+			"Resource": "arn:aws:s3:::bucket",
+			"Condition": {
+				"IpAddress": {"aws:SourceIp": "192.0.2.0/24"},
+				"StringLike": {"s3:prefix": "public/*"}
+			}
+		},
+		{
+			"Sid": "VersionsOnlyOverTLS",
+			"Effect": "Deny",
+			"Principal": "*",
+			"Action": "s3:GetObjectVersion",
+			"Resource": "arn:aws:s3:::bucket/*",
+			"Condition": {"Bool": {"aws:SecureTransport": "false"}}
+		}
+	]
+}`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy), "bucket")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	office := map[string][]string{"aws:sourceip": {"192.0.2.7"}, "s3:prefix": {"public/docs"}}
+	tests := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{
+			name: "anonymous public read",
+			req:  Request{Action: "s3:GetObject", Resource: ResourceARN("bucket", "public/a.txt")},
+			want: Allow,
+		},
+		{
+			name: "anonymous private read",
+			req:  Request{Action: "s3:GetObject", Resource: ResourceARN("bucket", "private/a.txt")},
+			want: NoMatch,
+		},
+		{
+			name: "deny overrides allow",
+			req:  Request{Principal: "bob", Action: "s3:GetObject", Resource: ResourceARN("bucket", "public/secret/key")},
+			want: Deny,
+		},
+		{
+			name: "principal by root arn",
+			req:  Request{Principal: "bob", Action: "s3:PutObject", Resource: ResourceARN("bucket", "key")},
+			want: Allow,
+		},
+		{
+			name: "action wildcard",
+			req:  Request{Principal: "bob", Action: "s3:DeleteObjectVersion", Resource: ResourceARN("bucket", "key")},
+			want: Allow,
+		},
+		{
+			name: "other principal",
+			req:  Request{Principal: "alice", Action: "s3:PutObject", Resource: ResourceARN("bucket", "key")},
+			want: NoMatch,
+		},
+		{
+			name: "anonymous never matches a principal id",
+			req:  Request{Action: "s3:PutObject", Resource: ResourceARN("bucket", "key")},
+			want: NoMatch,
+		},
+		{
+			name: "case insensitive action",
+			req:  Request{Principal: "alice", Action: "s3:ListBucket", Resource: ResourceARN("bucket", ""), Conditions: office},
+			want: Allow,
+		},
+		{
+			name: "AWS wildcard includes anonymous",
+			req:  Request{Action: "s3:ListBucket", Resource: ResourceARN("bucket", ""), Conditions: office},
+			want: Allow,
+		},
+		{
+			name: "condition outside network",
+			req: Request{Principal: "alice", Action: "s3:ListBucket", Resource: ResourceARN("bucket", ""),
+				Conditions: map[string][]string{"aws:sourceip": {"198.51.100.1"}, "s3:prefix": {"public/docs"}}},
+			want: NoMatch,
+		},
+		{
+			name: "condition key absent",
+			req: Request{Principal: "alice", Action: "s3:ListBucket", Resource: ResourceARN("bucket", ""),
+				Conditions: map[string][]string{"aws:sourceip": {"192.0.2.7"}}},
+			want: NoMatch,
+		},
+		{
+			name: "bool condition",
+			req: Request{Action: "s3:GetObjectVersion", Resource: ResourceARN("bucket", "public/a.txt"),
+				Conditions: map[string][]string{"aws:securetransport": {"false"}}},
+			want: Deny,
+		},
+		{
+			name: "bool condition not met",
+			req: Request{Action: "s3:GetObjectVersion", Resource: ResourceARN("bucket", "public/a.txt"),
+				Conditions: map[string][]string{"aws:securetransport": {"true"}}},
+			want: NoMatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Evaluate(&tt.req); got != tt.want {
+				t.Fatalf("Evaluate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	statement := func(s string) string {
+		return `{"Version": "2012-10-17", "Statement": [` + s + `]}`
+	}
+	tests := []struct {
+		name  string
+		doc   string
+		valid bool
+	}{
+		{
+			name:  "valid",
+			doc:   statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}`),
+			valid: true,
+		},
+		{
+			name:  "bucket wildcard",
+			doc:   statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::buck*/*"}`),
+			valid: true,
+		},
+		{name: "not json", doc: `{"Statement": `},
+		{name: "unknown field", doc: statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket", "Extra": 1}`)},
+		{name: "bad version", doc: `{"Version": "2020-01-01", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket"}]}`},
+		{name: "no statement", doc: `{"Version": "2012-10-17", "Statement": []}`},
+		{name: "bad effect", doc: statement(`{"Effect": "Maybe", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket"}`)},
+		{name: "missing principal", doc: statement(`{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket"}`)},
+		{name: "bad principal", doc: statement(`{"Effect": "Allow", "Principal": "bob", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket"}`)},
+		{name: "foreign action", doc: statement(`{"Effect": "Allow", "Principal": "*", "Action": "iam:PassRole", "Resource": "arn:aws:s3:::bucket"}`)},
+		{name: "other bucket", doc: statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::other/*"}`)},
+		{name: "not an arn", doc: statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "bucket/*"}`)},
+		{
+			name: "unknown operator",
+			doc:  statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket", "Condition": {"Guess": {"a": "b"}}}`),
+		},
+		{
+			name: "bad address",
+			doc:  statement(`{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket", "Condition": {"IpAddress": {"aws:SourceIp": "nowhere"}}}`),
+		},
+		{name: "too large", doc: statement(`{"Sid": "` + strings.Repeat("x", MaxSize) + `"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc), "bucket")
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Parse: %v", err)
+				}
+				return
+			}
+			if code := s3error.CodeOf(err); err == nil || code != s3error.ErrorCodeMalformedPolicy {
+				t.Fatalf("Parse = %v, want MalformedPolicy", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package postpolicy parses the base64 policy documents of browser form
+// uploads and checks the submitted form fields against their conditions.
+package postpolicy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+// condition is one entry of the conditions list: an exact match, a prefix
+// match or the content-length-range.
+type condition struct {
+	op    string
+	field string
+	value string
+}
+
+// Policy is a decoded POST policy document.
+type Policy struct {
+	Expiration time.Time
+	conditions []condition
+	// MinLength and MaxLength bound the file size when HasLengthRange.
+	MinLength      int64
+	MaxLength      int64
+	HasLengthRange bool
+}
+
+func invalid(format string, args ...interface{}) error {
+	return s3error.S3Error{OriginError: fmt.Errorf(format, args...), Code: s3error.ErrorCodeInvalidPolicyDocument}
+}
+
+func denied(format string, args ...interface{}) error {
+	return s3error.S3Error{
+		OriginError: fmt.Errorf("Invalid according to Policy: "+format, args...),
+		Code:        s3error.ErrorCodeAccessDenied,
+	}
+}
+
+// Parse decodes the base64 policy form field.
+func Parse(encoded string) (*Policy, error) {
+	doc, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalid("policy is not valid base64: %v", err)
+	}
+	var raw struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, invalid("policy is not valid JSON: %v", err)
+	}
+	p := &Policy{}
+	if p.Expiration, err = time.Parse(time.RFC3339Nano, raw.Expiration); err != nil {
+		return nil, invalid("invalid policy expiration '%s'", raw.Expiration)
+	}
+	for _, c := range raw.Conditions {
+		if err := p.addCondition(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) addCondition(raw json.RawMessage) error {
+	// {"field": "value"} is shorthand for ["eq", "$field", "value"].
+	var exact map[string]string
+	if err := json.Unmarshal(raw, &exact); err == nil {
+		if len(exact) != 1 {
+			return invalid("a condition object must hold one field, got %s", raw)
+		}
+		for field, value := range exact {
+			p.conditions = append(p.conditions, condition{op: "eq", field: strings.ToLower(field), value: value})
+		}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var list []interface{}
+	if err := dec.Decode(&list); err != nil || len(list) != 3 {
+		return invalid("invalid condition %s", raw)
+	}
+	op, _ := list[0].(string)
+	switch op = strings.ToLower(op); op {
+	case "content-length-range":
+		min, ok1 := number(list[1])
+		max, ok2 := number(list[2])
+		if !ok1 || !ok2 || min < 0 || min > max {
+			return invalid("invalid content-length-range %s", raw)
+		}
+		p.MinLength, p.MaxLength, p.HasLengthRange = min, max, true
+	case "eq", "starts-with":
+		field, ok1 := list[1].(string)
+		value, ok2 := list[2].(string)
+		if !ok1 || !ok2 || !strings.HasPrefix(field, "$") {
+			return invalid("invalid condition %s", raw)
+		}
+		p.conditions = append(p.conditions, condition{op: op, field: strings.ToLower(field[1:]), value: value})
+	default:
+		return invalid("unknown condition operator '%s'", list[0])
+	}
+	return nil
+}
+
+// number accepts the bounds of content-length-range as JSON numbers or
+// numeric strings.
+func number(v interface{}) (int64, bool) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// exempt fields need no condition of their own.
+func exempt(field string) bool {
+	switch field {
+	case "policy", "x-amz-signature", "file", "bucket":
+		return true
+	}
+	return strings.HasPrefix(field, "x-ignore-")
+}
+
+// Check verifies fields, keyed by their lower-cased names and including
+// "bucket", against the policy as of now. Every field but the exempt ones
+// must be covered by a condition.
+func (p *Policy) Check(fields map[string]string, now time.Time) error {
+	if !now.Before(p.Expiration) {
+		return denied("Policy expired.")
+	}
+	covered := make(map[string]bool, len(p.conditions))
+	for _, c := range p.conditions {
+		value, ok := fields[c.field]
+		switch c.op {
+		case "eq":
+			if !ok || value != c.value {
+				return denied("Policy Condition failed: [\"eq\", \"$%s\", \"%s\"]", c.field, c.value)
+			}
+		case "starts-with":
+			if !ok || !strings.HasPrefix(value, c.value) {
+				return denied("Policy Condition failed: [\"starts-with\", \"$%s\", \"%s\"]", c.field, c.value)
+			}
+		}
+		covered[c.field] = true
+	}
+	for field := range fields {
+		if !exempt(field) && !covered[field] {
+			return denied("Extra input fields: %s", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package postpolicy
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/dashjay/overlay_oss/pkg/s3error"
+)
+
+func encode(doc string) string {
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+const testPolicy = `{
+	"expiration": "2030-01-01T12:00:00.000Z",
+	"conditions": [
+		{"bucket": "uploads"},
+		["starts-with", "$key", "user/alice/"],
+		{"acl": "public-read"},
+		["eq", "$Success_Action_Status", "201"],
+		["starts-with", "$Content-Type", ""],
+		["content-length-range", 1, "1048576"]
+	]
+}`
+
+var testExpiration = time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestCheck(t *testing.T) {
+	p, err := Parse(encode(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !p.HasLengthRange || p.MinLength != 1 || p.MaxLength != 1048576 {
+		t.Fatalf("length range = %v [%d, %d], want [1, 1048576]", p.HasLengthRange, p.MinLength, p.MaxLength)
+	}
+	fields := func(change func(map[string]string)) map[string]string {
+		f := map[string]string{
+			"bucket":                "uploads",
+			"key":                   "user/alice/photo.jpg",
+			"acl":                   "public-read",
+			"success_action_status": "201",
+			"content-type":          "image/jpeg",
+			"policy":                "ignored",
+			"x-amz-signature":       "ignored",
+			"x-ignore-tracking":     "ignored",
+		}
+		if change != nil {
+			change(f)
+		}
+		return f
+	}
+	before := testExpiration.Add(-time.Minute)
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		now     time.Time
+		allowed bool
+	}{
+		{name: "valid", fields: fields(nil), now: before, allowed: true},
+		{name: "expired", fields: fields(nil), now: testExpiration},
+		{name: "other bucket", fields: fields(func(f map[string]string) { f["bucket"] = "other" }), now: before},
+		{name: "key outside prefix", fields: fields(func(f map[string]string) { f["key"] = "user/bob/photo.jpg" }), now: before},
+		{name: "key equal to prefix", fields: fields(func(f map[string]string) { f["key"] = "user/alice/" }), now: before, allowed: true},
+		{name: "missing eq field", fields: fields(func(f map[string]string) { delete(f, "acl") }), now: before},
+		{name: "wrong eq value", fields: fields(func(f map[string]string) { f["acl"] = "public-read-write" }), now: before},
+		{name: "empty prefix matches anything", fields: fields(func(f map[string]string) { f["content-type"] = "" }), now: before, allowed: true},
+		{name: "empty prefix still requires the field", fields: fields(func(f map[string]string) { delete(f, "content-type") }), now: before},
+		{name: "extra field", fields: fields(func(f map[string]string) { f["x-amz-meta-owner"] = "alice" }), now: before},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.fields, tt.now)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("Check: %v", err)
+				}
+				return
+			}
+			if err == nil || s3error.CodeOf(err) != s3error.ErrorCodeAccessDenied {
+				t.Fatalf("Check = %v, want AccessDenied", err)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	withConditions := func(c string) string {
+		return encode(`{"expiration": "2030-01-01T12:00:00Z", "conditions": [` + c + `]}`)
+	}
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"not base64", "%%%"},
+		{"not json", encode(`{"expiration": `)},
+		{"bad expiration", encode(`{"expiration": "tomorrow", "conditions": []}`)},
+		{"object with two fields", withConditions(`{"acl": "private", "key": "a"}`)},
+		{"short list", withConditions(`["eq", "$key"]`)},
+		{"field without $", withConditions(`["eq", "key", "a"]`)},
+		{"unknown operator", withConditions(`["ends-with", "$key", "a"]`)},
+		{"negative length", withConditions(`["content-length-range", -1, 10]`)},
+		{"inverted length range", withConditions(`["content-length-range", 10, 1]`)},
+		{"non numeric length", withConditions(`["content-length-range", "one", 10]`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.encoded)
+			if err == nil || s3error.CodeOf(err) != s3error.ErrorCodeInvalidPolicyDocument {
+				t.Fatalf("Parse = %v, want InvalidPolicyDocument", err)
+			}
+		})
+	}
+}
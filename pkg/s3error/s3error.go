@@ -2,8 +2,10 @@ package s3error
 
 import (
 	"encoding/xml"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"html"
 	"io"
 	"net/http"
 	"os"
@@ -53,10 +55,14 @@ func (s S3Error) HTTPStatusCode() int {
 }
 
 func IsS3Error(err error, code ErrorCode) bool {
-	var s3err *S3Error
+	var s3err S3Error
 	if errors.As(err, &s3err) {
 		return s3err.GetCode() == code
 	}
+	var s3errPtr *S3Error
+	if errors.As(err, &s3errPtr) {
+		return s3errPtr.GetCode() == code
+	}
 	return false
 }
 
@@ -68,6 +74,33 @@ func IsNotFound(err error) bool {
 	return IsNoSuchKey(err) || IsS3Error(err, ErrorCodeNoSuchBucket)
 }
 
+// CodeOf returns the S3 error code of err, InternalError for errors that
+// carry none.
+func CodeOf(err error) ErrorCode {
+	var s3err S3Error
+	if errors.As(err, &s3err) {
+		return s3err.GetCode()
+	}
+	var s3errPtr *S3Error
+	if errors.As(err, &s3errPtr) {
+		return s3errPtr.GetCode()
+	}
+	return ErrorCodeInternalError
+}
+
+// StatusCodeOf returns the HTTP status err is answered with.
+func StatusCodeOf(err error) int {
+	var s3err S3Error
+	if errors.As(err, &s3err) {
+		return s3err.HTTPStatusCode()
+	}
+	var s3errPtr *S3Error
+	if errors.As(err, &s3errPtr) {
+		return s3errPtr.HTTPStatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
 var _ error = S3Error{}
 
 func WriteError(r *http.Request, w http.ResponseWriter, err error) {
@@ -105,6 +138,26 @@ func WriteError(r *http.Request, w http.ResponseWriter, err error) {
 	}
 }
 
+// WriteHTMLError answers err with an HTML page, the way website endpoints
+// report errors to browsers.
+func WriteHTMLError(r *http.Request, w http.ResponseWriter, err error) {
+	code, status := CodeOf(err), StatusCodeOf(err)
+	if code == ErrorCodeInternalError {
+		logrus.WithError(err).Errorln("request error")
+	}
+	id, _ := r.Context().Value("RequestId").(string)
+	title := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	body := fmt.Sprintf("<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n"+
+		"<li>Code: %s</li>\n<li>Message: %s</li>\n<li>RequestId: %s</li>\n</ul>\n<hr/>\n</body>\n</html>\n",
+		title, title, html.EscapeString(string(code)), html.EscapeString(err.Error()), html.EscapeString(id))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = io.WriteString(w, body)
+	}
+}
+
 var (
 	ErrorCodeAccessDenied                                   ErrorCode = "AccessDenied"
 	ErrorCodeAccountProblem                                 ErrorCode = "AccountProblem"
@@ -136,18 +189,22 @@ var (
 	ErrorCodeInvalidObjectState                             ErrorCode = "InvalidObjectState"
 	ErrorCodeInvalidPart                                    ErrorCode = "InvalidPart"
 	ErrorCodeInvalidPartOrder                               ErrorCode = "InvalidPartOrder"
+	ErrorCodeInvalidPartNumber                              ErrorCode = "InvalidPartNumber"
 	ErrorCodeInvalidPayer                                   ErrorCode = "InvalidPayer"
 	ErrorCodeInvalidPolicyDocument                          ErrorCode = "InvalidPolicyDocument"
 	ErrorCodeInvalidRange                                   ErrorCode = "InvalidRange"
+	ErrorCodeInvalidRequest                                 ErrorCode = "InvalidRequest"
 	ErrorCodeInvalidSecurity                                ErrorCode = "InvalidSecurity"
 	ErrorCodeInvalidSOAPRequest                             ErrorCode = "InvalidSOAPRequest"
 	ErrorCodeInvalidStorageClass                            ErrorCode = "InvalidStorageClass"
+	ErrorCodeInvalidTag                                     ErrorCode = "InvalidTag"
 	ErrorCodeInvalidTargetBucketForLogging                  ErrorCode = "InvalidTargetBucketForLogging"
 	ErrorCodeInvalidToken                                   ErrorCode = "InvalidToken"
 	ErrorCodeInvalidURI                                     ErrorCode = "InvalidURI"
 	ErrorCodeKeyTooLongError                                ErrorCode = "KeyTooLongError"
 	ErrorCodeMalformedACLError                              ErrorCode = "MalformedACLError"
 	ErrorCodeMalformedPOSTRequest                           ErrorCode = "MalformedPOSTRequest"
+	ErrorCodeMalformedPolicy                                ErrorCode = "MalformedPolicy"
 	ErrorCodeMalformedXML                                   ErrorCode = "MalformedXML"
 	ErrorCodeMaxMessageLengthExceeded                       ErrorCode = "MaxMessageLengthExceeded"
 	ErrorCodeMaxPostPreDataLengthExceededError              ErrorCode = "MaxPostPreDataLengthExceededError"
@@ -161,10 +218,13 @@ var (
 	ErrorCodeNoLoggingStatusForKey                          ErrorCode = "NoLoggingStatusForKey"
 	ErrorCodeNoSuchBucket                                   ErrorCode = "NoSuchBucket"
 	ErrorCodeNoSuchBucketPolicy                             ErrorCode = "NoSuchBucketPolicy"
+	ErrorCodeNoSuchCORSConfiguration                        ErrorCode = "NoSuchCORSConfiguration"
 	ErrorCodeNoSuchKey                                      ErrorCode = "NoSuchKey"
 	ErrorCodeNoSuchLifecycleConfiguration                   ErrorCode = "NoSuchLifecycleConfiguration"
+	ErrorCodeNoSuchTagSet                                   ErrorCode = "NoSuchTagSet"
 	ErrorCodeNoSuchUpload                                   ErrorCode = "NoSuchUpload"
 	ErrorCodeNoSuchVersion                                  ErrorCode = "NoSuchVersion"
+	ErrorCodeNoSuchWebsiteConfiguration                     ErrorCode = "NoSuchWebsiteConfiguration"
 	ErrorCodeNotImplemented                                 ErrorCode = "NotImplemented"
 	ErrorCodeNotSignedUp                                    ErrorCode = "NotSignedUp"
 	ErrorCodeOperationAborted                               ErrorCode = "OperationAborted"
@@ -309,6 +369,10 @@ var errMap = map[ErrorCode]errDetail{
 		"The list of parts was not in ascending order. Parts list must be specified in order by part number.",
 		400,
 	},
+	ErrorCodeInvalidPartNumber: {
+		"The requested partnumber is not satisfiable.",
+		416,
+	},
 	ErrorCodeInvalidPayer: {
 		"All access to this object has been disabled. Please contact AWS Support for further assistance, see Contact Us.",
 		403,
@@ -321,6 +385,10 @@ var errMap = map[ErrorCode]errDetail{
 		"The requested range cannot be satisfied.",
 		416,
 	},
+	ErrorCodeInvalidRequest: {
+		"Invalid Request.",
+		400,
+	},
 	ErrorCodeInvalidSecurity: {
 		"The provided security credentials are not valid.",
 		403,
@@ -333,6 +401,10 @@ var errMap = map[ErrorCode]errDetail{
 		"The storage class you specified is not valid.",
 		400,
 	},
+	ErrorCodeInvalidTag: {
+		"The tag provided was not a valid tag.",
+		400,
+	},
 	ErrorCodeInvalidTargetBucketForLogging: {
 		"The target bucket for logging does not exist, is not owned by you, or does not have the appropriate grants for the log-delivery group.",
 		400,
@@ -357,6 +429,10 @@ var errMap = map[ErrorCode]errDetail{
 		"The body of your POST request is not well-formed multipart/form-data.",
 		400,
 	},
+	ErrorCodeMalformedPolicy: {
+		"The bucket policy document is not valid.",
+		400,
+	},
 	ErrorCodeMalformedXML: {
 		"This happens when the user sends malformed XML (XML that doesn't conform to the published XSD) for the configuration. The error message is, \"The XML you provided was not well-formed or did not validate against our published schema.\"",
 		400,
@@ -409,6 +485,10 @@ var errMap = map[ErrorCode]errDetail{
 		"The specified bucket does not have a bucket policy.",
 		404,
 	},
+	ErrorCodeNoSuchCORSConfiguration: {
+		"The CORS configuration does not exist.",
+		404,
+	},
 	ErrorCodeNoSuchKey: {
 		"The specified key does not exist.",
 		404,
@@ -417,6 +497,10 @@ var errMap = map[ErrorCode]errDetail{
 		"The lifecycle configuration does not exist.",
 		404,
 	},
+	ErrorCodeNoSuchTagSet: {
+		"The TagSet does not exist.",
+		404,
+	},
 	ErrorCodeNoSuchUpload: {
 		"The specified multipart upload does not exist. The upload ID might be invalid, or the multipart upload might have been aborted or completed.",
 		404,
@@ -425,6 +509,10 @@ var errMap = map[ErrorCode]errDetail{
 		"Indicates that the version ID specified in the request does not match an existing version.",
 		404,
 	},
+	ErrorCodeNoSuchWebsiteConfiguration: {
+		"The specified bucket does not have a website configuration.",
+		404,
+	},
 	ErrorCodeNotImplemented: {
 		"A header you provided implies functionality that is not implemented.",
 		501,
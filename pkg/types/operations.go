@@ -13,6 +13,18 @@ const (
 const (
 	PutBucket S3Operation = 100*S3Operation(AdminBucketReq) + iota
 	DeleteBucket
+	PutBucketVersioning
+	PutBucketTagging
+	DeleteBucketTagging
+	PutBucketLifecycle
+	DeleteBucketLifecycle
+	PutBucketPolicy
+	DeleteBucketPolicy
+	PutBucketAcl
+	PutBucketCors
+	DeleteBucketCors
+	PutBucketWebsite
+	DeleteBucketWebsite
 )
 const (
 	ListBuckets S3Operation = 100*S3Operation(ListBucketsReq) + iota
@@ -23,6 +35,18 @@ const (
 	GetObject
 	HeadObject
 	GetBucketVersions
+	GetBucketVersioning
+	GetBucketTagging
+	GetObjectTagging
+	GetBucketLifecycle
+	GetBucketPolicy
+	GetBucketAcl
+	GetObjectAcl
+	GetBucketCors
+	// PreflightObject is a CORS preflight OPTIONS request on a bucket or
+	// an object.
+	PreflightObject
+	GetBucketWebsite
 )
 const (
 	PutObject = 100*S3Operation(WriteBucketReq) + iota
@@ -35,6 +59,10 @@ const (
 	AbortMultipartUpload
 	ListBucketMultiUploads
 	DeleteObjects
+	PutObjectTagging
+	DeleteObjectTagging
+	PutObjectAcl
+	PostObject
 )
 
 const (
@@ -56,17 +84,43 @@ var m = map[S3Operation]string{
 	AbortMultipartUpload:    "AbortMultipartUpload",
 	ListBucketMultiUploads:  "ListBucketMultiUploads",
 	DeleteObjects:           "DeleteObjects",
+	PutObjectTagging:        "PutObjectTagging",
+	DeleteObjectTagging:     "DeleteObjectTagging",
+	PutObjectAcl:            "PutObjectAcl",
+	PostObject:              "PostObject",
 
-	GetBucket:         "GetBucket",
-	GetObject:         "GetObject",
-	HeadObject:        "HeadObject",
-	GetBucketVersions: "GetBucketVersions",
+	GetBucket:           "GetBucket",
+	GetObject:           "GetObject",
+	HeadObject:          "HeadObject",
+	GetBucketVersions:   "GetBucketVersions",
+	GetBucketVersioning: "GetBucketVersioning",
+	GetBucketTagging:    "GetBucketTagging",
+	GetObjectTagging:    "GetObjectTagging",
+	GetBucketLifecycle:  "GetBucketLifecycle",
+	GetBucketPolicy:     "GetBucketPolicy",
+	GetBucketAcl:        "GetBucketAcl",
+	GetObjectAcl:        "GetObjectAcl",
+	GetBucketCors:       "GetBucketCors",
+	PreflightObject:     "PreflightObject",
+	GetBucketWebsite:    "GetBucketWebsite",
 
 	ListBuckets: "ListBuckets",
 	HeadBucket:  "HeadBucket",
 
-	PutBucket:    "PutBucket",
-	DeleteBucket: "DeleteBucket",
+	PutBucket:             "PutBucket",
+	DeleteBucket:          "DeleteBucket",
+	PutBucketVersioning:   "PutBucketVersioning",
+	PutBucketTagging:      "PutBucketTagging",
+	DeleteBucketTagging:   "DeleteBucketTagging",
+	PutBucketLifecycle:    "PutBucketLifecycle",
+	DeleteBucketLifecycle: "DeleteBucketLifecycle",
+	PutBucketPolicy:       "PutBucketPolicy",
+	DeleteBucketPolicy:    "DeleteBucketPolicy",
+	PutBucketAcl:          "PutBucketAcl",
+	PutBucketCors:         "PutBucketCors",
+	DeleteBucketCors:      "DeleteBucketCors",
+	PutBucketWebsite:      "PutBucketWebsite",
+	DeleteBucketWebsite:   "DeleteBucketWebsite",
 }
 
 func (s3 S3Operation) String() string {
@@ -6,13 +6,16 @@ type S3Object struct {
 	VersionId string
 }
 type ListQuery struct {
-	Version         int
-	Prefix          string
-	Delimiter       string
-	Marker          string
-	MaxKeys         int64
-	KeyMarker       string // only works with `VersionIdMarker`
-	VersionIdMarker string
+	Version           int
+	Prefix            string
+	Delimiter         string
+	Marker            string // V1 only
+	StartAfter        string // V2 only
+	ContinuationToken string // V2 only
+	EncodingType      string
+	MaxKeys           int64
+	KeyMarker         string // only works with `VersionIdMarker`
+	VersionIdMarker   string
 }
 type MultipartQuery struct {
 	Uploads        bool
@@ -32,6 +35,9 @@ type S3Query struct {
 	MpQuery       MultipartQuery
 	ListQuery     ListQuery
 	BatchDelQuery bool
+	// VirtualHost is set when the bucket was named by the Host header
+	// rather than the first path segment.
+	VirtualHost bool
 }
 
 func (q S3Query) HasCopy() bool {